@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"chip8-go/chip8"
+)
+
+// Bits of the dots of a braille character by their position in its 2x4 cell,
+// as laid out in Unicode starting at U+2800.
+var brailleDots = [2][4]rune{
+	{0x01, 0x02, 0x04, 0x40},
+	{0x08, 0x10, 0x20, 0x80},
+}
+
+// Returns the display as lines of braille characters, each showing a cell of
+// 2x4 pixels with a dot for each pixel that is on.
+func brailleLines(
+	gfx *[chip8.DisplayWidth][chip8.DisplayHeight]uint8) []string {
+	var lines []string
+	for y := 0; y < chip8.DisplayHeight; y += 4 {
+		var b strings.Builder
+		for x := 0; x < chip8.DisplayWidth; x += 2 {
+			r := rune(0x2800)
+			for dx := range brailleDots {
+				for dy, dot := range brailleDots[dx] {
+					if gfx[x+dx][y+dy] != 0 {
+						r |= dot
+					}
+				}
+			}
+			b.WriteRune(r)
+		}
+		lines = append(lines, b.String())
+	}
+	return lines
+}
+
+// Returns the ANSI escape sequence selecting a 24-bit foreground (code 38) or
+// background (code 48) color.
+func ansiColor(code int, c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("\x1b[%d;2;%d;%d;%dm", code, r>>8, g>>8, b>>8)
+}
+
+// Runs without opening a window, drawing the display to the terminal in
+// braille. There is no input.
+func runTerminal(c8 *chip8.Chip8, palette chip8.Palette) error {
+	ips := *speed
+	if ips == 0 {
+		ips = chip8.DefaultSpeed
+	}
+	runner := chip8.NewRunner(c8, ips)
+	beep := &bell{w: os.Stdout}
+	if !*bellEnabled {
+		beep.w = io.Discard
+	}
+	colors := ansiColor(38, palette[1]) + ansiColor(48, palette[0])
+	fmt.Print("\x1b[2J")       // Clear screen
+	defer fmt.Print("\x1b[0m") // Reset colors
+	for {
+		if _, err := runner.Advance(nil); err != nil {
+			return err
+		}
+		if c8.Draw {
+			fmt.Print("\x1b[H" + colors) // Cursor to top left
+			fmt.Print(strings.Join(brailleLines(&runner.Display), "\n"))
+		}
+		beep.update(c8.SoundActive())
+		time.Sleep(runner.Idle())
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"chip8-go/chip8"
+)
+
+func TestBrailleLines(t *testing.T) {
+	tests := []struct {
+		name   string
+		pixels [][2]int // Pixels on, in the cell at the top left
+		want   rune
+	}{
+		{"blank", nil, 0x2800},
+		{"top left", [][2]int{{0, 0}}, 0x2801},
+		{"bottom right", [][2]int{{1, 3}}, 0x2880},
+		{"left column", [][2]int{{0, 0}, {0, 1}, {0, 2}, {0, 3}}, 0x2847},
+		{"right column", [][2]int{{1, 0}, {1, 1}, {1, 2}, {1, 3}}, 0x28b8},
+		{"top row", [][2]int{{0, 0}, {1, 0}}, 0x2809},
+		{"full", [][2]int{{0, 0}, {0, 1}, {0, 2}, {0, 3},
+			{1, 0}, {1, 1}, {1, 2}, {1, 3}}, 0x28ff},
+	}
+	for _, test := range tests {
+		var gfx [chip8.DisplayWidth][chip8.DisplayHeight]uint8
+		for _, p := range test.pixels {
+			gfx[p[0]][p[1]] = 1
+		}
+		// The same cell at the bottom right, with a plane 2 value.
+		for _, p := range test.pixels {
+			gfx[chip8.DisplayWidth-2+p[0]][chip8.DisplayHeight-4+p[1]] = 2
+		}
+		lines := brailleLines(&gfx)
+		if len(lines) != chip8.DisplayHeight/4 {
+			t.Fatalf("%d lines, want %d", len(lines), chip8.DisplayHeight/4)
+		}
+		for _, line := range lines {
+			if n := utf8.RuneCountInString(line); n != chip8.DisplayWidth/2 {
+				t.Fatalf("%d characters in %q, want %d", n, line,
+					chip8.DisplayWidth/2)
+			}
+		}
+		first, _ := utf8.DecodeRuneInString(lines[0])
+		last, _ := utf8.DecodeLastRuneInString(lines[len(lines)-1])
+		if first != test.want || last != test.want {
+			t.Errorf("%s: %U and %U, want %U", test.name, first, last,
+				test.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package chip8
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// BatchStatus is the outcome of running a ROM in a batch.
+type BatchStatus string
+
+const (
+	BatchOK      BatchStatus = "ok"
+	BatchCrashed BatchStatus = "crashed" // Failed to load, errored or panicked
+	BatchHung    BatchStatus = "hung"    // Waited for a key for a second
+	BatchNoDraw  BatchStatus = "no draw" // Ran without drawing anything
+)
+
+// BatchResult is the result of running a ROM in a batch.
+type BatchResult struct {
+	Name   string // File name of the ROM
+	Status BatchStatus
+	Frames int    // Frames run
+	Hash   uint64 // FrameHash of the display at the end
+	Err    error  // Why the ROM crashed
+}
+
+// RunBatch runs each file in dir as a ROM, headless and without input, for
+// d of emulated time at speed instructions per second, with the quirks
+// profile known for it. The results are sorted by name. A ROM that crashes,
+// even by panicking, doesn't stop the others from running.
+func RunBatch(dir string, d time.Duration, speed int) ([]BatchResult, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var results []BatchResult
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		results = append(results,
+			runBatchRom(filepath.Join(dir, entry.Name()), d, speed))
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	return results, nil
+}
+
+func runBatchRom(path string, d time.Duration, speed int) (res BatchResult) {
+	res.Name = filepath.Base(path)
+	c8 := New()
+	c8.SetSeed(1)
+	runner := NewRunner(c8, speed)
+	defer func() {
+		if v := recover(); v != nil {
+			res.Status, res.Err = BatchCrashed, fmt.Errorf("Panic: %v", v)
+		}
+		res.Hash = c8.FrameHash()
+	}()
+	if err := c8.LoadRom(path); err != nil {
+		res.Status, res.Err = BatchCrashed, err
+		return res
+	}
+	if name, ok := LookupProfile(c8.RomHash()); ok {
+		c8.Quirks = Profiles[name]
+	}
+	// Fx0A is retried every instruction without input.
+	c8.InputTimeout = speed
+	drew := false
+	c8.AfterCycle = func(c8 *Chip8, op uint16) {
+		drew = drew || c8.Draw
+	}
+	frames, err := runner.RunFor(d)
+	res.Frames = frames
+	switch {
+	case errors.Is(err, ErrInputTimeout):
+		res.Status = BatchHung
+	case err != nil:
+		res.Status, res.Err = BatchCrashed, err
+	case !drew:
+		res.Status = BatchNoDraw
+	default:
+		res.Status = BatchOK
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+package chip8
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Runs a program of random instructions, restarting it when it fails.
+func BenchmarkStepMixed(b *testing.B) {
+	c8 := New()
+	c8.SetSeed(1)
+	prog := randomProgram(rand.New(rand.NewSource(1)), 256)
+	if err := c8.LoadRomData(prog); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c8.Step(); err != nil {
+			c8.Reset()
+		}
+	}
+}
+
+// Runs frames of a loop typical of games: clear the display, draw a row of
+// font digits, update a counter and wait for the delay timer.
+func BenchmarkRunFrame(b *testing.B) {
+	c8 := New()
+	c8.SetSeed(1)
+	err := c8.LoadRomData([]uint8{
+		0x00, 0xe0, // 0x200: CLS
+		0x60, 0x00, // LD V0, 0
+		0x61, 0x00, // LD V1, 0
+		0xf0, 0x29, // 0x206: LD F, V0
+		0xd1, 0x25, // DRW V1, V2, 5
+		0x71, 0x05, // ADD V1, 5
+		0x70, 0x01, // ADD V0, 1
+		0x30, 0x10, // SE V0, 16
+		0x12, 0x06, // JP 0x206
+		0x72, 0x01, // ADD V2, 1
+		0xc3, 0x0f, // RND V3, 0x0f
+		0x83, 0x34, // ADD V3, V3
+		0xf3, 0x15, // LD DT, V3
+		0x12, 0x00, // JP 0x200
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	r := NewRunner(c8, DefaultSpeed)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := r.RunFrame(nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -9,17 +9,27 @@ package chip8
 import (
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"math/bits"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	DisplayWidth  = 64
 	DisplayHeight = 32
-	maxRomSize    = 0xfff - 0x200 + 1
 )
 
+// RomLoadAddr is the address ROMs are loaded at and programs start at.
+const RomLoadAddr uint16 = 0x200
+
+// MaxRomSize is the size of the largest ROM that fits in memory.
+const MaxRomSize = 0x1000 - int(RomLoadAddr)
+
 var fontset = [...]uint8{
 	0xf0, 0x90, 0x90, 0x90, 0xf0, // 0
 	0x20, 0x60, 0x20, 0x20, 0x70, // 1
@@ -41,9 +51,56 @@ var fontset = [...]uint8{
 
 type opcode uint16
 
+// Number of bitplanes of the display. Each pixel of Gfx holds one bit per
+// plane, classic Chip-8 programs only use the first.
+const maxPlanes = 2
+
+// DrawMode selects how Dxyn combines sprites with the display.
+type DrawMode uint8
+
+const (
+	// Toggle pixels, VF is set if a pixel is turned off. This is the standard
+	// Chip-8 behavior.
+	DrawXOR DrawMode = iota
+	// Turn pixels on, VF is set if a pixel was already on.
+	DrawOR
+)
+
+// SysPolicy selects how 0nnn (SYS addr) is executed.
+type SysPolicy uint8
+
+const (
+	SysIgnore   SysPolicy = iota // Skip the instruction, like modern interpreters
+	SysError                     // Fail with an unknown opcode error
+	SysCallback                  // Call OnSys with the address
+)
+
+// PastEndPolicy selects what happens when PC runs past the end of the loaded
+// ROM into memory that was never loaded, which almost always means the
+// program ran off its end and executes zeros.
+type PastEndPolicy uint8
+
+const (
+	PastEndWarn   PastEndPolicy = iota // Warn once through Warn
+	PastEndIgnore                      // Execute silently
+	PastEndHalt                        // Warn and halt, see Halted
+)
+
+// ReservedPolicy selects what happens when the program writes below
+// RomLoadAddr, memory reserved for the interpreter and font. Programs may read
+// there, e.g. font sprites, but a write usually means I was left pointing at a
+// font sprite.
+type ReservedPolicy uint8
+
+const (
+	ReservedWarn    ReservedPolicy = iota // Write, warn through Warn
+	ReservedAllow                         // Write silently
+	ReservedProtect                       // Warn and ignore the write
+)
+
 type Chip8 struct {
 	Gfx    [DisplayWidth][DisplayHeight]uint8
-	Key    [0x10]bool
+	Key    [0x10]bool // Use SetKey when emulation runs concurrently
 	Draw   bool
 	mem    [0x1000]uint8
 	v      [0x10]uint8
@@ -51,35 +108,189 @@ type Chip8 struct {
 	i, pc  uint16
 	sp     uint8
 	dt, st uint8 // Delay timer & sound timer
+	planes uint8 // Bitplanes drawn to, a mask
 	timer  *time.Ticker
+	rom    []uint8
+	interp []uint8 // Memory below RomLoadAddr, under the font
+	seed   int64
+	rng    *rand.Rand
+
+	romName     string
+	stopped     bool
+	halted      bool   // Whether the program exited, see Halted
+	pastEnd     bool   // Whether PC ran past the end of the ROM
+	frame       uint64 // Number of timer ticks since reset
+	keyMu       sync.Mutex
+	keyQueue    []keyEvent
+	keyWait     keyWait
+	breakpoints map[uint16]bool
+	trace       [traceSize]traceEntry
+	traceLen    uint64 // Number of instructions traced since reset
+	searchHits  []uint16
+	searchMem   [0x1000]uint8 // Memory as of the last search
+	pcCounts    []uint64      // Executions per address, if profiling
+	logWrites   bool
+	writeLog    []MemWrite
+
+	executing atomic.Bool // Guards against concurrent execution
+	written   uint16      // Registers written since reset, bit n for Vn
+
+	inputWaits int // Times Fx0A found no key since the last one
+
+	recordRandom bool
+	randomLog    []byte // Random bytes used since reset, if recording
+	replay       []byte // Random bytes to use instead of the generator
+	replayPos    int
+
+	Quirks   Quirks
+	DrawMode DrawMode
+	Sys      SysPolicy
+	OnSys    func(addr uint16)
+	Reserved ReservedPolicy
+	PastEnd  PastEndPolicy
+	// CheckSprites enables warnings about DRW reading sprite data past the end
+	// of memory, which usually means I is wrong.
+	CheckSprites bool
+	// CheckAlignment enables warnings about fetching an instruction at an odd
+	// address, which usually means a bad jump target. Off by default as some
+	// programs are deliberately misaligned.
+	CheckAlignment bool
+	// CheckRegisters enables warnings about reading a register before it was
+	// written, which gives whatever value it was left with.
+	CheckRegisters bool
+	// InputTimeout is the number of times Fx0A may find no key, being
+	// executed again or waiting for input, before failing with
+	// ErrInputTimeout. Zero means no limit. It keeps runs without input, e.g.
+	// tests, from waiting forever.
+	InputTimeout int
+	// AfterCycle is called after each instruction executed successfully by
+	// Cycle, Step or a Runner, before the timers tick if the instruction ends
+	// a frame. It must not execute instructions itself.
+	AfterCycle func(c8 *Chip8, op uint16)
+	// OnFrame is called at the end of each 60 hz frame, by Cycle, a Runner
+	// or StepNTimed, after the timers are decremented and the key events
+	// queued for the new frame are applied. Not called while stopped.
+	OnFrame func(c8 *Chip8)
+	// IdleDetection makes a Runner end a frame early when the program spins
+	// waiting for the delay timer, see WaitingForTimer, instead of burning
+	// CPU on instructions that can't change anything before the next frame.
+	IdleDetection bool
+	// StickyKeys makes SetKey latch a key pressed until it is pressed again,
+	// releases being ignored, for players who can't hold keys. Programs that
+	// expect keys to be held only briefly, e.g. to move one step at a time,
+	// may not play well with it.
+	StickyKeys bool
+	// Warn is called with diagnostics about the running program.
+	Warn func(msg string)
 }
 
 func New() *Chip8 {
 	c8 := new(Chip8)
-	for i, x := range fontset {
-		c8.mem[i] = x
-	}
-	c8.pc = 0x200
+	c8.seed = time.Now().UnixNano()
+	c8.Quirks = Profiles[DefaultProfile]
+	c8.reset()
 	c8.timer = time.NewTicker(time.Second / 60)
 	return c8
 }
 
+// Reset restarts the loaded ROM on a cleared machine, as on power-on.
+func (c8 *Chip8) Reset() {
+	c8.reset()
+	c8.Gfx = [DisplayWidth][DisplayHeight]uint8{}
+	c8.Draw = true
+}
+
+// WarmReset restarts the loaded ROM like Reset, but leaves the display as it
+// is. Useful for keeping the output of the previous run as a reference.
+func (c8 *Chip8) WarmReset() {
+	c8.reset()
+	c8.Draw = false
+}
+
+// Reinitializes memory and CPU state, keeping the display and keypad.
+func (c8 *Chip8) reset() {
+	c8.mem = [len(c8.mem)]uint8{}
+	copy(c8.mem[:], c8.interp)
+	copy(c8.mem[:], fontset[:])
+	copy(c8.mem[RomLoadAddr:], c8.rom)
+	c8.v = [len(c8.v)]uint8{}
+	c8.stack = [len(c8.stack)]uint16{}
+	c8.i, c8.pc = 0, RomLoadAddr
+	c8.sp = 0
+	c8.dt, c8.st = 0, 0
+	c8.planes = 1
+	c8.keyWait = keyWait{}
+	c8.rng = rand.New(rand.NewSource(c8.seed))
+	c8.traceLen = 0
+	c8.frame = 0
+	c8.written = 0
+	c8.inputWaits = 0
+	c8.halted = false
+	c8.pastEnd = false
+	c8.randomLog = nil
+	c8.replayPos = 0
+}
+
+// SetSeed seeds the random number generator used by Cxkk, making runs
+// reproducible.
+func (c8 *Chip8) SetSeed(seed int64) {
+	c8.seed = seed
+	c8.rng = rand.New(rand.NewSource(seed))
+}
+
+func (c8 *Chip8) Seed() int64 {
+	return c8.seed
+}
+
 func (c8 *Chip8) LoadRom(romPath string) error {
-	rom, err := os.Open(romPath)
+	rom, err := os.ReadFile(romPath)
 	if err != nil {
 		return fmt.Errorf("Error reading ROM file: %v", err)
 	}
-	defer rom.Close()
-	bytesRead, err := rom.Read(c8.mem[0x200:])
-	if err != nil {
-		return fmt.Errorf("Error reading ROM file: %v", err)
+	if err := c8.LoadRomData(rom); err != nil {
+		return err
 	}
-	if bytesRead > maxRomSize {
+	c8.romName = filepath.Base(romPath)
+	return nil
+}
+
+// LoadRomData loads a ROM image into memory at RomLoadAddr.
+func (c8 *Chip8) LoadRomData(rom []uint8) error {
+	if len(rom) > MaxRomSize {
 		return errors.New("ROM file too big")
 	}
+	copy(c8.mem[RomLoadAddr:], rom)
+	c8.rom = append([]uint8(nil), rom...)
+	c8.romName = ""
 	return nil
 }
 
+// LoadInterpreter loads an image of interpreter code, e.g. of the COSMAC VIP
+// interpreter, into memory below RomLoadAddr for programs reading there. The
+// font is kept at its address on top of it. By default the memory only holds
+// the font.
+func (c8 *Chip8) LoadInterpreter(image []uint8) error {
+	if len(image) > int(RomLoadAddr) {
+		return fmt.Errorf("Interpreter image too big, max size is %d bytes",
+			RomLoadAddr)
+	}
+	c8.interp = append([]uint8(nil), image...)
+	copy(c8.mem[:], c8.interp)
+	copy(c8.mem[:], fontset[:])
+	return nil
+}
+
+// Halted reports whether the program exited with 00FD. No more instructions
+// are executed until reset.
+func (c8 *Chip8) Halted() bool {
+	return c8.halted
+}
+
+// RomSize returns the size of the loaded ROM in bytes.
+func (c8 *Chip8) RomSize() int {
+	return len(c8.rom)
+}
+
 func (c8 *Chip8) incPc(skipNextInstruction bool) {
 	if skipNextInstruction {
 		c8.pc += 4
@@ -88,30 +299,98 @@ func (c8 *Chip8) incPc(skipNextInstruction bool) {
 	}
 }
 
-// Emulates one Chip-8 cycle. Comments describing opcodes are copied from
-// Cowgod's reference [1].
+// Emulates one Chip-8 cycle. The timers are decremented at a 60 hz rate
+// measured in real time, also while Fx0A blocks in waitForInput, see Runner for
+// paced emulation.
 func (c8 *Chip8) Cycle(waitForInput func()) error {
+	if c8.stopped {
+		return nil
+	}
+	if err := c8.exec(waitForInput); err != nil {
+		return err
+	}
+	// Decrement timers at 60 hz rate. See Cowgod's reference [1].
+	select {
+	case <-c8.timer.C:
+		c8.tick()
+	default:
+	}
+	return nil
+}
+
+// Executes one instruction. Comments describing opcodes are copied from
+// Cowgod's reference [1].
+func (c8 *Chip8) exec(waitForInput func()) (err error) {
+	if !c8.executing.CompareAndSwap(false, true) {
+		return ErrConcurrentExecution
+	}
+	defer c8.executing.Store(false)
+	if c8.halted {
+		return nil
+	}
+	if int(c8.pc)+1 >= len(c8.mem) {
+		return fmt.Errorf("PC out of bounds: 0x%x", c8.pc)
+	}
+	if c8.pc >= RomLoadAddr+uint16(len(c8.rom)) && !c8.pastEnd &&
+		c8.PastEnd != PastEndIgnore {
+		c8.pastEnd = true
+		c8.warn("PC ran past the end of the ROM")
+		if c8.PastEnd == PastEndHalt {
+			c8.halted = true
+			return nil
+		}
+	}
+	if c8.CheckAlignment && c8.pc%2 != 0 {
+		c8.warn("Instruction fetched at odd address")
+	}
 	op := (uint16(c8.mem[c8.pc]) << 8) | uint16(c8.mem[c8.pc+1])
+	if c8.AfterCycle != nil {
+		defer func() {
+			if err == nil {
+				c8.AfterCycle(c8, op)
+			}
+		}()
+	}
+	c8.trace[c8.traceLen%traceSize] = traceEntry{c8.pc, op}
+	c8.traceLen++
+	if c8.pcCounts != nil {
+		c8.pcCounts[c8.pc]++
+	}
 	c8.Draw = false
+	if c8.CheckRegisters {
+		c8.checkRegisters(op)
+	}
 	switch op & 0xf000 {
 	case 0x0000:
-		switch op & 0xff {
-		case 0xe0:
+		switch op {
+		case 0x00e0:
 			// 00E0 - CLS -- Clear the display.
 			for i := range c8.Gfx {
 				for j := range c8.Gfx[i] {
-					c8.Gfx[i][j] = 0
+					c8.Gfx[i][j] &^= c8.planes
 				}
 			}
 			c8.Draw = true
-		case 0xee:
+		case 0x00ee:
 			// 00EE - RET -- Return from a subroutine.
 			c8.sp--
 			c8.pc = c8.stack[c8.sp]
+		case 0x00fd:
+			// 00FD - EXIT -- Exit the interpreter (SCHIP).
+			// PC is left at the instruction.
+			c8.halted = true
+			return nil
 		default:
 			// 0nnn - SYS addr -- Jump to a machine code routine at nnn.
 			// Apparently ignored in modern interpreters.
-			return errUnknown(op)
+			switch c8.Sys {
+			case SysError:
+				return errUnknown(op)
+			case SysCallback:
+				if c8.OnSys != nil {
+					c8.OnSys(op & 0xfff)
+				}
+			}
 		}
 		c8.incPc(false)
 	case 0x1000:
@@ -158,6 +437,9 @@ func (c8 *Chip8) Cycle(waitForInput func()) error {
 		// 8XYN X and Y identify data registers, N the operation
 		x := uint8((op & 0xf00) >> 8)
 		y := uint8((op & 0xf0) >> 4)
+		// VF may itself be Vx or Vy, so the flag is written after the result
+		// and is what remains in VF (see also Quirks.ResultLast).
+		var flag uint8
 		switch op & 0xf {
 		case 0x0:
 			// 8xy0 - LD Vx, Vy -- Set Vx = Vy.
@@ -165,47 +447,55 @@ func (c8 *Chip8) Cycle(waitForInput func()) error {
 		case 0x1:
 			// 8xy1 - OR Vx, Vy -- Set Vx = Vx OR Vy.
 			c8.v[x] |= c8.v[y]
+			if c8.Quirks.VFReset {
+				c8.v[0xf] = 0
+			}
 		case 0x2:
 			// 8xy2 - AND Vx, Vy -- Set Vx = Vx AND Vy.
 			c8.v[x] &= c8.v[y]
+			if c8.Quirks.VFReset {
+				c8.v[0xf] = 0
+			}
 		case 0x3:
 			// 8xy3 - XOR Vx, Vy -- Set Vx = Vx XOR Vy.
 			c8.v[x] ^= c8.v[y]
+			if c8.Quirks.VFReset {
+				c8.v[0xf] = 0
+			}
 		case 0x4:
 			// 8xy4 - ADD Vx, Vy -- Set Vx = Vx + Vy, set VF = carry.
 			if c8.v[y] > (0xff - c8.v[x]) {
-				c8.v[0xf] = 1
-			} else {
-				c8.v[0xf] = 0
+				flag = 1
 			}
-			c8.v[x] += c8.v[y]
+			c8.setResult(x, c8.v[x]+c8.v[y], flag)
 		case 0x5:
 			// 8xy5 - SUB Vx, Vy -- Set Vx = Vx - Vy, set VF = NOT borrow.
-			if c8.v[x] > c8.v[y] {
-				c8.v[0xf] = 1
-			} else {
-				c8.v[0xf] = 0
+			if c8.v[x] >= c8.v[y] {
+				flag = 1
 			}
-			c8.v[x] -= c8.v[y]
+			c8.setResult(x, c8.v[x]-c8.v[y], flag)
 		case 0x6:
 			// 8xy6 - SHR Vx {, Vy} -- Set Vx = Vx SHR 1.
-			c8.v[0xf] = c8.v[x] & 0x1
+			if !c8.Quirks.Shift {
+				c8.v[x] = c8.v[y]
+			}
+			flag = c8.v[x] & 0x1
 			c8.v[x] >>= 1
+			c8.v[0xf] = flag
 		case 0x7:
 			// 8xy7 - SUBN Vx, Vy -- Set Vx = Vy - Vx, set VF = NOT borrow.
-			x := uint8((op & 0xf00) >> 8)
-			y := uint8((op & 0xf0) >> 4)
-			if c8.v[y] > c8.v[x] {
-				c8.v[0xf] = 1
-			} else {
-				c8.v[0xf] = 0
+			if c8.v[y] >= c8.v[x] {
+				flag = 1
 			}
-			c8.v[x] = c8.v[y] - c8.v[x]
+			c8.setResult(x, c8.v[y]-c8.v[x], flag)
 		case 0xe:
 			// 8xyE - SHL Vx {, Vy} -- Set Vx = Vx SHL 1.
-			x := uint8((op & 0xf00) >> 8)
-			c8.v[0xf] = (x & 0x80) >> 7
+			if !c8.Quirks.Shift {
+				c8.v[x] = c8.v[y]
+			}
+			flag = (c8.v[x] & 0x80) >> 7
 			c8.v[x] <<= 1
+			c8.v[0xf] = flag
 		default:
 			return errUnknown(op)
 		}
@@ -226,12 +516,17 @@ func (c8 *Chip8) Cycle(waitForInput func()) error {
 		c8.incPc(false)
 	case 0xb000:
 		// Bnnn - JP V0, addr -- Jump to location nnn + V0.
-		c8.pc = (op & 0xfff) + uint16(c8.v[0])
+		if c8.Quirks.Jump {
+			// Bxnn - JP Vx, addr -- Jump to location xnn + Vx.
+			c8.pc = (op & 0xfff) + uint16(c8.v[(op&0xf00)>>8])
+		} else {
+			c8.pc = (op & 0xfff) + uint16(c8.v[0])
+		}
 	case 0xc000:
 		// Cxkk - RND Vx, byte -- Set Vx = random byte AND kk.
 		x := uint8((op & 0xf00) >> 8)
 		kk := uint8(op & 0xff)
-		c8.v[x] = kk & uint8(rand.Intn(0x100))
+		c8.v[x] = kk & c8.randomByte()
 		c8.incPc(false)
 	case 0xd000:
 		// Dxyn - DRW Vx, Vy, nibble -- Display n-byte sprite starting at memory
@@ -239,21 +534,25 @@ func (c8 *Chip8) Cycle(waitForInput func()) error {
 		x := uint8((op & 0xf00) >> 8)
 		y := uint8((op & 0xf0) >> 4)
 		n := uint8(op & 0xf)
-		c8.v[0xf] = 0
-		for row := uint8(0); row < n; row++ {
-			spriteRow := c8.mem[c8.i+uint16(row)]
-			for col := uint8(0); col < 8; col++ {
-				if spriteRow&uint8(0x1<<(7-col)) != 0 {
-					// Wrap around if sprite is at the edge
-					i := (c8.v[x] + col) % DisplayWidth
-					j := (c8.v[y] + row) % DisplayHeight
-					c8.Gfx[i][j] ^= 1
-					if c8.Gfx[i][j] == 0 {
-						c8.v[0xf] = 1
-					}
-				}
-			}
+		if n == 0 {
+			// Dxy0 draws nothing in Chip-8 and leaves VF unchanged. (It draws
+			// a 16x16 sprite in the extended mode of SCHIP.)
+			c8.incPc(false)
+			break
+		}
+		// With several planes selected, the sprite of each plane follows the
+		// previous one in memory.
+		size := int(n) * bits.OnesCount8(c8.planes)
+		if c8.CheckSprites && int(c8.i)+size > len(c8.mem) {
+			c8.warn("DRW reads sprite past end of memory (I=0x%x, n=%d)", c8.i, n)
 		}
+		var buf [0xf * maxPlanes]uint8
+		sprite := buf[:size]
+		for k := range sprite {
+			sprite[k] = c8.mem[(int(c8.i)+k)%len(c8.mem)]
+		}
+		// The coordinates are read before VF is set, so VF may be Vx or Vy.
+		c8.v[0xf] = c8.drawSprite(c8.v[x], c8.v[y], sprite, n)
 		c8.Draw = true
 		c8.incPc(false)
 	case 0xe000:
@@ -262,31 +561,54 @@ func (c8 *Chip8) Cycle(waitForInput func()) error {
 		case 0x9e:
 			// Ex9E - SKP Vx -- Skip next instruction if key with the value of Vx is
 			// pressed.
-			c8.incPc(c8.Key[c8.v[x]])
+			c8.incPc(c8.KeyPressed(c8.v[x]))
 		case 0xa1:
 			// ExA1 - SKNP Vx -- Skip next instruction if key with the value of Vx is
 			// not pressed.
-			c8.incPc(!c8.Key[c8.v[x]])
+			c8.incPc(!c8.KeyPressed(c8.v[x]))
 		default:
 			return errUnknown(op)
 		}
 	case 0xf000:
 		x := uint8((op & 0xf00) >> 8)
 		switch op & 0xff {
+		case 0x1:
+			// Fn01 - PLANE n -- Select the bitplanes drawn to (XO-CHIP).
+			c8.planes = x & (1<<maxPlanes - 1)
 		case 0x7:
 			// Fx07 - LD Vx, DT -- Set Vx = delay timer value.
 			c8.v[x] = c8.dt
 		case 0xa:
 			// Fx0A - LD Vx, K -- Wait for a key press, store the value of the key in
 			// Vx.
+			// Like Octo, a key counts once it is released, and only if it was
+			// pressed during the wait.
 		Waiting:
 			for {
+				if key, ok := c8.waitedKey(); ok {
+					c8.v[x] = key
+					c8.inputWaits = 0
+					break Waiting
+				}
+				c8.inputWaits++
+				if c8.InputTimeout > 0 && c8.inputWaits > c8.InputTimeout {
+					c8.inputWaits = 0
+					return ErrInputTimeout
+				}
+				if waitForInput == nil {
+					// Leave pc as is, the instruction is retried next cycle.
+					return nil
+				}
 				waitForInput()
-				for i := uint8(0); i < 0x10; i++ {
-					if c8.Key[i] {
-						c8.v[x] = i
-						break Waiting
-					}
+				if c8.stopped {
+					// E.g. to quit, the instruction is retried when resumed.
+					return nil
+				}
+				// The timers keep running while blocked, see Cycle.
+				select {
+				case <-c8.timer.C:
+					c8.tick()
+				default:
 				}
 			}
 		case 0x15:
@@ -307,14 +629,17 @@ func (c8 *Chip8) Cycle(waitForInput func()) error {
 		case 0x33:
 			// Fx33 - LD B, Vx -- Store BCD representation of Vx in memory locations
 			// I, I+1, and I+2.
-			c8.mem[c8.i] = c8.v[x] / 100
-			c8.mem[c8.i+1] = (c8.v[x] % 100) / 10
-			c8.mem[c8.i+2] = c8.v[x] % 10
+			c8.store(c8.i, c8.v[x]/100)
+			c8.store(c8.i+1, (c8.v[x]%100)/10)
+			c8.store(c8.i+2, c8.v[x]%10)
 		case 0x55:
 			// Fx55 - LD [I], Vx -- Store registers V0 through Vx in memory starting
 			// at location I.
 			for i := uint8(0); i < x+1; i++ {
-				c8.mem[c8.i+uint16(i)] = c8.v[i]
+				c8.store(c8.i+uint16(i), c8.v[i])
+			}
+			if c8.Quirks.LoadStore {
+				c8.i += uint16(x) + 1
 			}
 		case 0x65:
 			// Fx65 - LD Vx, [I] -- Read registers V0 through Vx from memory starting
@@ -322,6 +647,9 @@ func (c8 *Chip8) Cycle(waitForInput func()) error {
 			for i := uint8(0); i < x+1; i++ {
 				c8.v[i] = c8.mem[c8.i+uint16(i)]
 			}
+			if c8.Quirks.LoadStore {
+				c8.i += uint16(x) + 1
+			}
 		default:
 			return errUnknown(op)
 		}
@@ -329,21 +657,185 @@ func (c8 *Chip8) Cycle(waitForInput func()) error {
 	default:
 		return errUnknown(op)
 	}
-	// Decrement timers at 60 hz rate. See Cowgod's reference [1].
-	select {
-	case <-c8.timer.C:
-		if c8.dt > 0 {
-			c8.dt--
+	return nil
+}
+
+// Ends a frame unless stopped, see endFrame. Called at a rate of 60 hz.
+func (c8 *Chip8) tick() {
+	if c8.stopped {
+		return
+	}
+	c8.endFrame()
+}
+
+// Ends a frame: decrements the delay and sound timers and applies queued key
+// events of the next frame. Unlike tick, also when stopped, for StepNTimed.
+func (c8 *Chip8) endFrame() {
+	c8.frame++
+	c8.applyKeyEvents()
+	if waiting, _ := c8.WaitingForKey(); !waiting ||
+		!c8.Quirks.WaitKeyFreezesTimers {
+		c8.tickTimers()
+	}
+	if c8.OnFrame != nil {
+		c8.OnFrame(c8)
+	}
+}
+
+func (c8 *Chip8) tickTimers() {
+	if c8.dt > 0 {
+		c8.dt--
+	}
+	if c8.st > 0 {
+		c8.st--
+	}
+}
+
+// Frame returns the number of frames, i.e. 60 hz timer ticks, since reset.
+func (c8 *Chip8) Frame() uint64 {
+	return c8.frame
+}
+
+// Draws a sprite of n rows at (x, y) as Dxyn, returning 1 if there was a
+// collision. With several planes selected, the rows of each plane follow
+// those of the previous one in sprite.
+func (c8 *Chip8) drawSprite(x, y uint8, sprite []uint8, n uint8) uint8 {
+	if c8.Quirks.ClampStartCoord && (x >= DisplayWidth || y >= DisplayHeight) {
+		// Taken as is, the sprite is entirely outside the display.
+		return 0
+	}
+	x0, y0 := x%DisplayWidth, y%DisplayHeight
+	collision := uint8(0)
+	k := 0
+	for plane := uint8(1); plane < 1<<maxPlanes; plane <<= 1 {
+		if c8.planes&plane == 0 {
+			continue
 		}
-		if c8.st > 0 {
-			fmt.Print("\a")
-			c8.st--
+		for row := uint8(0); row < n; row++ {
+			spriteRow := sprite[k]
+			k++
+			for col := uint8(0); col < 8; col++ {
+				if spriteRow&uint8(0x1<<(7-col)) != 0 {
+					i, j := x0+col, y0+row
+					if i >= DisplayWidth || j >= DisplayHeight {
+						if c8.Quirks.Clip {
+							continue
+						}
+						// Wrap around if sprite is at the edge
+						i, j = i%DisplayWidth, j%DisplayHeight
+					}
+					// Only the planes drawn to can collide.
+					if c8.Gfx[i][j]&plane != 0 {
+						collision = 1
+					}
+					if c8.DrawMode == DrawOR {
+						c8.Gfx[i][j] |= plane
+					} else {
+						c8.Gfx[i][j] ^= plane
+					}
+				}
+			}
 		}
-	default:
 	}
-	return nil
+	return collision
 }
 
+// DrawSpriteAt draws a sprite, one byte per row, at (x, y) as Dxyn would from
+// memory, and reports whether there was a collision. Memory and registers,
+// including VF, are left as they are. Useful for demos and tests.
+func (c8 *Chip8) DrawSpriteAt(sprite []byte, x, y uint8) (collision bool) {
+	planes := bits.OnesCount8(c8.planes)
+	if planes == 0 {
+		return false
+	}
+	c8.Draw = true
+	return c8.drawSprite(x, y, sprite, uint8(len(sprite)/planes)) == 1
+}
+
+// Display returns a copy of the display, indexed by x then y like Gfx.
+func (c8 *Chip8) Display() [][]uint8 {
+	display := make([][]uint8, len(c8.Gfx))
+	for x := range c8.Gfx {
+		display[x] = append([]uint8(nil), c8.Gfx[x][:]...)
+	}
+	return display
+}
+
+// FrameHash returns a hash of the display contents, for cheaply comparing
+// frames.
+func (c8 *Chip8) FrameHash() uint64 {
+	h := fnv.New64a()
+	for x := range c8.Gfx {
+		h.Write(c8.Gfx[x][:])
+	}
+	return h.Sum64()
+}
+
+// SoundActive reports whether the sound timer is running, i.e. whether a
+// tone should be playing.
+func (c8 *Chip8) SoundActive() bool {
+	return c8.st > 0 && !c8.stopped
+}
+
+// SoundTimerValue returns the value of the sound timer, which counts down at
+// 60 Hz.
+func (c8 *Chip8) SoundTimerValue() uint8 {
+	return c8.st
+}
+
+// DelayTimerValue returns the value of the delay timer, which counts down at
+// 60 Hz.
+func (c8 *Chip8) DelayTimerValue() uint8 {
+	return c8.dt
+}
+
+// Sets Vx to the result of 8xy4, 8xy5 or 8xy7 and VF to the flag, in the order
+// selected by Quirks.ResultLast.
+func (c8 *Chip8) setResult(x, result, flag uint8) {
+	if c8.Quirks.ResultLast {
+		c8.v[0xf] = flag
+		c8.v[x] = result
+	} else {
+		c8.v[x] = result
+		c8.v[0xf] = flag
+	}
+}
+
+// Writes a byte of memory on behalf of the program.
+func (c8 *Chip8) store(addr uint16, value uint8) {
+	if c8.logWrites {
+		c8.writeLog = append(c8.writeLog, MemWrite{addr, value, c8.pc})
+	}
+	if addr < RomLoadAddr && c8.Reserved != ReservedAllow {
+		c8.warn("Write to reserved memory at 0x%03x (I=0x%03x)", addr, c8.i)
+		if c8.Reserved == ReservedProtect {
+			return
+		}
+	}
+	c8.mem[addr] = value
+}
+
+// Reports a diagnostic through Warn, prefixed with the current address.
+func (c8 *Chip8) warn(format string, args ...interface{}) {
+	if c8.Warn != nil {
+		c8.Warn(fmt.Sprintf("0x%03x: ", c8.pc) + fmt.Sprintf(format, args...))
+	}
+}
+
+// ErrConcurrentExecution is returned when an instruction is executed while
+// another one still is, e.g. calling Step from one goroutine while another
+// calls Cycle. Execution is not safe for concurrent use.
+var ErrConcurrentExecution = errors.New("Concurrent execution")
+
+// ErrInputTimeout is returned when Fx0A waits for a key longer than
+// InputTimeout.
+var ErrInputTimeout = errors.New("Timed out waiting for a key")
+
 func errUnknown(op uint16) error {
+	if op == 0xf000 {
+		// Memory is fixed at 4KB, so it can't be grown for XO-CHIP.
+		return fmt.Errorf("Unknown opcode 0x%x: XO-CHIP long load (F000 "+
+			"nnnn) needs 64KB of memory, which is not supported", op)
+	}
 	return fmt.Errorf("Unknown opcode 0x%x", op)
 }
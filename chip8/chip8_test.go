@@ -0,0 +1,2120 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "Update the golden files in testdata")
+
+// Returns a machine with rom loaded and a fixed seed.
+func newTestChip8(t *testing.T, rom ...uint8) *Chip8 {
+	t.Helper()
+	c8 := New()
+	c8.SetSeed(1)
+	if err := c8.LoadRomData(rom); err != nil {
+		t.Fatal(err)
+	}
+	return c8
+}
+
+// An instruction test: setup prepares the machine, the instruction at 0x200
+// is executed once, then check inspects the result.
+type opTest struct {
+	name  string
+	op    uint16
+	setup func(c8 *Chip8)
+	check func(t *testing.T, c8 *Chip8)
+}
+
+func wantPC(t *testing.T, c8 *Chip8, pc uint16) {
+	t.Helper()
+	if c8.pc != pc {
+		t.Errorf("PC = 0x%03x, want 0x%03x", c8.pc, pc)
+	}
+}
+
+func wantV(t *testing.T, c8 *Chip8, x int, v uint8) {
+	t.Helper()
+	if c8.v[x] != v {
+		t.Errorf("V%X = 0x%02x, want 0x%02x", x, c8.v[x], v)
+	}
+}
+
+func wantI(t *testing.T, c8 *Chip8, i uint16) {
+	t.Helper()
+	if c8.i != i {
+		t.Errorf("I = 0x%03x, want 0x%03x", c8.i, i)
+	}
+}
+
+// Sets the registers from pairs of register and value.
+func setV(regs ...int) func(c8 *Chip8) {
+	return func(c8 *Chip8) {
+		for k := 0; k < len(regs); k += 2 {
+			c8.v[regs[k]] = uint8(regs[k+1])
+		}
+	}
+}
+
+// Checks the registers from pairs of register and value, and that the next
+// instruction follows.
+func checkV(regs ...int) func(t *testing.T, c8 *Chip8) {
+	return func(t *testing.T, c8 *Chip8) {
+		for k := 0; k < len(regs); k += 2 {
+			wantV(t, c8, regs[k], uint8(regs[k+1]))
+		}
+		wantPC(t, c8, 0x202)
+	}
+}
+
+func checkPC(pc uint16) func(t *testing.T, c8 *Chip8) {
+	return func(t *testing.T, c8 *Chip8) { wantPC(t, c8, pc) }
+}
+
+var opTests = []opTest{
+	{"00E0 CLS", 0x00e0,
+		func(c8 *Chip8) { c8.Gfx[3][4], c8.Gfx[63][31] = 1, 1 },
+		func(t *testing.T, c8 *Chip8) {
+			if c8.Gfx != [DisplayWidth][DisplayHeight]uint8{} {
+				t.Error("display not cleared")
+			}
+			if !c8.Draw {
+				t.Error("Draw not set")
+			}
+			wantPC(t, c8, 0x202)
+		}},
+	{"00EE RET", 0x00ee,
+		func(c8 *Chip8) { c8.stack[0], c8.sp = 0x300, 1 },
+		func(t *testing.T, c8 *Chip8) {
+			wantPC(t, c8, 0x302)
+			if c8.sp != 0 {
+				t.Errorf("SP = %d, want 0", c8.sp)
+			}
+		}},
+	{"00FD EXIT", 0x00fd, nil,
+		func(t *testing.T, c8 *Chip8) {
+			if !c8.Halted() {
+				t.Error("not halted")
+			}
+			wantPC(t, c8, 0x200)
+		}},
+	{"0nnn SYS ignored", 0x0123, nil, checkPC(0x202)},
+	{"1nnn JP", 0x1345, nil, checkPC(0x345)},
+	{"2nnn CALL", 0x2345, nil,
+		func(t *testing.T, c8 *Chip8) {
+			wantPC(t, c8, 0x345)
+			if c8.sp != 1 || c8.stack[0] != 0x200 {
+				t.Errorf("SP = %d, stack[0] = 0x%03x, want 1, 0x200",
+					c8.sp, c8.stack[0])
+			}
+		}},
+	{"3xkk SE taken", 0x3a42, setV(0xa, 0x42), checkPC(0x204)},
+	{"3xkk SE not taken", 0x3a42, setV(0xa, 0x41), checkPC(0x202)},
+	{"4xkk SNE taken", 0x4a42, setV(0xa, 0x41), checkPC(0x204)},
+	{"4xkk SNE not taken", 0x4a42, setV(0xa, 0x42), checkPC(0x202)},
+	{"5xy0 SE taken", 0x5120, setV(1, 7, 2, 7), checkPC(0x204)},
+	{"5xy0 SE not taken", 0x5120, setV(1, 7, 2, 8), checkPC(0x202)},
+	{"6xkk LD", 0x6a42, nil, checkV(0xa, 0x42)},
+	{"7xkk ADD", 0x7a02, setV(0xa, 0x40), checkV(0xa, 0x42)},
+	{"7xkk ADD wraps, VF unchanged", 0x7a02, setV(0xa, 0xff, 0xf, 7),
+		checkV(0xa, 0x01, 0xf, 7)},
+	{"8xy0 LD", 0x8120, setV(2, 9), checkV(1, 9, 2, 9)},
+	{"8xy1 OR", 0x8121, setV(1, 0x0c, 2, 0x0a, 0xf, 7),
+		checkV(1, 0x0e, 0xf, 7)},
+	{"8xy2 AND", 0x8122, setV(1, 0x0c, 2, 0x0a), checkV(1, 0x08)},
+	{"8xy3 XOR", 0x8123, setV(1, 0x0c, 2, 0x0a), checkV(1, 0x06)},
+	{"8xy4 ADD no carry", 0x8124, setV(1, 0xfe, 2, 0x01, 0xf, 7),
+		checkV(1, 0xff, 0xf, 0)},
+	{"8xy4 ADD carry", 0x8124, setV(1, 0xff, 2, 0x01),
+		checkV(1, 0x00, 0xf, 1)},
+	{"8xy4 ADD VF as Vx", 0x8f14, setV(0xf, 0xff, 1, 0x02), checkV(0xf, 1)},
+	{"8xy4 ADD VF as Vy", 0x81f4, setV(1, 0xff, 0xf, 0x02),
+		checkV(1, 0x01, 0xf, 1)},
+	{"8xy5 SUB no borrow", 0x8125, setV(1, 5, 2, 3), checkV(1, 2, 0xf, 1)},
+	{"8xy5 SUB equal", 0x8125, setV(1, 5, 2, 5), checkV(1, 0, 0xf, 1)},
+	{"8xy5 SUB borrow", 0x8125, setV(1, 3, 2, 5), checkV(1, 0xfe, 0xf, 0)},
+	{"8xy6 SHR", 0x8126, setV(1, 0x05, 2, 0xf0), checkV(1, 0x02, 0xf, 1)},
+	{"8xy6 SHR no carry", 0x8126, setV(1, 0x04), checkV(1, 0x02, 0xf, 0)},
+	{"8xy7 SUBN no borrow", 0x8127, setV(1, 3, 2, 5), checkV(1, 2, 0xf, 1)},
+	{"8xy7 SUBN equal", 0x8127, setV(1, 5, 2, 5), checkV(1, 0, 0xf, 1)},
+	{"8xy7 SUBN borrow", 0x8127, setV(1, 5, 2, 3), checkV(1, 0xfe, 0xf, 0)},
+	{"8xyE SHL", 0x812e, setV(1, 0x81, 2, 0x0f), checkV(1, 0x02, 0xf, 1)},
+	{"8xyE SHL no carry", 0x812e, setV(1, 0x41), checkV(1, 0x82, 0xf, 0)},
+	{"9xy0 SNE taken", 0x9120, setV(1, 7, 2, 8), checkPC(0x204)},
+	{"9xy0 SNE not taken", 0x9120, setV(1, 7, 2, 7), checkPC(0x202)},
+	{"Annn LD I", 0xa123, nil,
+		func(t *testing.T, c8 *Chip8) {
+			wantI(t, c8, 0x123)
+			wantPC(t, c8, 0x202)
+		}},
+	{"Bnnn JP V0", 0xb300, setV(0, 0x10, 3, 0x20), checkPC(0x310)},
+	{"Cxkk RND masked", 0xc10f, setV(1, 0xff),
+		func(t *testing.T, c8 *Chip8) {
+			if c8.v[1]&^0x0f != 0 {
+				t.Errorf("V1 = 0x%02x, not masked by 0x0f", c8.v[1])
+			}
+			wantPC(t, c8, 0x202)
+		}},
+	{"Dxyn DRW", 0xd125,
+		func(c8 *Chip8) { c8.i = 0; c8.v[1], c8.v[2] = 10, 5 }, // Font 0
+		func(t *testing.T, c8 *Chip8) {
+			// 0xf0, 0x90, 0x90, 0x90, 0xf0
+			for col := 0; col < 8; col++ {
+				want := uint8(0)
+				if col < 4 {
+					want = 1
+				}
+				if c8.Gfx[10+col][5] != want {
+					t.Errorf("Gfx[%d][5] = %d, want %d",
+						10+col, c8.Gfx[10+col][5], want)
+				}
+			}
+			if c8.Gfx[10][6] != 1 || c8.Gfx[11][6] != 0 || c8.Gfx[13][6] != 1 {
+				t.Error("second sprite row wrong")
+			}
+			wantV(t, c8, 0xf, 0)
+			if !c8.Draw {
+				t.Error("Draw not set")
+			}
+			wantPC(t, c8, 0x202)
+		}},
+	{"Dxyn DRW collision", 0xd121,
+		func(c8 *Chip8) { c8.i = 0; c8.Gfx[3][0] = 1; c8.v[0xf] = 0 },
+		func(t *testing.T, c8 *Chip8) {
+			if c8.Gfx[3][0] != 0 || c8.Gfx[0][0] != 1 {
+				t.Error("pixels not toggled")
+			}
+			wantV(t, c8, 0xf, 1)
+		}},
+	{"Dxyn DRW wraps", 0xd121,
+		func(c8 *Chip8) { c8.i = 0; c8.v[1], c8.v[2] = 62, 31 },
+		func(t *testing.T, c8 *Chip8) {
+			if c8.Gfx[62][31] != 1 || c8.Gfx[63][31] != 1 ||
+				c8.Gfx[0][31] != 1 || c8.Gfx[1][31] != 1 {
+				t.Error("sprite not wrapped")
+			}
+		}},
+	{"Dxyn DRW coordinates wrap", 0xd121,
+		func(c8 *Chip8) { c8.i = 0; c8.v[1], c8.v[2] = 64+2, 32+3 },
+		func(t *testing.T, c8 *Chip8) {
+			if c8.Gfx[2][3] != 1 || c8.Gfx[5][3] != 1 {
+				t.Error("sprite not drawn at wrapped coordinates")
+			}
+		}},
+	{"Ex9E SKP pressed", 0xe19e,
+		func(c8 *Chip8) { c8.v[1] = 5; c8.SetKey(5, true) }, checkPC(0x204)},
+	{"Ex9E SKP not pressed", 0xe19e, setV(1, 5), checkPC(0x202)},
+	{"ExA1 SKNP pressed", 0xe1a1,
+		func(c8 *Chip8) { c8.v[1] = 5; c8.SetKey(5, true) }, checkPC(0x202)},
+	{"ExA1 SKNP not pressed", 0xe1a1, setV(1, 5), checkPC(0x204)},
+	{"Fn01 PLANE", 0xf301, nil,
+		func(t *testing.T, c8 *Chip8) {
+			if c8.planes != 3 {
+				t.Errorf("planes = %d, want 3", c8.planes)
+			}
+		}},
+	{"Fx07 LD Vx, DT", 0xf107, func(c8 *Chip8) { c8.dt = 9 }, checkV(1, 9)},
+	{"Fx0A LD Vx, K no key", 0xf10a, nil, checkPC(0x200)},
+	{"Fx0A LD Vx, K", 0xf10a,
+		func(c8 *Chip8) {
+			c8.keyWait.active = true
+			c8.SetKey(0xb, true)
+			c8.SetKey(0xb, false)
+		},
+		checkV(1, 0xb)},
+	{"Fx15 LD DT, Vx", 0xf115, setV(1, 9),
+		func(t *testing.T, c8 *Chip8) {
+			if c8.dt != 9 {
+				t.Errorf("DT = %d, want 9", c8.dt)
+			}
+		}},
+	{"Fx18 LD ST, Vx", 0xf118, setV(1, 9),
+		func(t *testing.T, c8 *Chip8) {
+			if c8.st != 9 {
+				t.Errorf("ST = %d, want 9", c8.st)
+			}
+		}},
+	{"Fx1E ADD I, Vx", 0xf11e,
+		func(c8 *Chip8) { c8.i = 0x100; c8.v[1] = 5 },
+		func(t *testing.T, c8 *Chip8) { wantI(t, c8, 0x105) }},
+	{"Fx29 LD F, Vx", 0xf129, setV(1, 0xa),
+		func(t *testing.T, c8 *Chip8) { wantI(t, c8, 50) }},
+	{"Fx33 LD B, Vx", 0xf133,
+		func(c8 *Chip8) { c8.i = 0x300; c8.v[1] = 254 },
+		func(t *testing.T, c8 *Chip8) {
+			if m := c8.mem[0x300:0x303]; m[0] != 2 || m[1] != 5 || m[2] != 4 {
+				t.Errorf("memory = %v, want [2 5 4]", m)
+			}
+			wantI(t, c8, 0x300)
+		}},
+	{"Fx55 LD [I], Vx", 0xf255,
+		func(c8 *Chip8) { c8.i = 0x300; setV(0, 1, 1, 2, 2, 3, 3, 4)(c8) },
+		func(t *testing.T, c8 *Chip8) {
+			if m := c8.mem[0x300:0x304]; m[0] != 1 || m[1] != 2 || m[2] != 3 ||
+				m[3] != 0 {
+				t.Errorf("memory = %v, want [1 2 3 0]", m)
+			}
+			wantI(t, c8, 0x300)
+		}},
+	{"Fx65 LD Vx, [I]", 0xf265,
+		func(c8 *Chip8) {
+			c8.i = 0x300
+			copy(c8.mem[0x300:], []uint8{1, 2, 3, 4})
+		},
+		func(t *testing.T, c8 *Chip8) {
+			checkV(0, 1, 1, 2, 2, 3, 3, 0)(t, c8)
+			wantI(t, c8, 0x300)
+		}},
+}
+
+func TestOpcodes(t *testing.T) {
+	for _, test := range opTests {
+		t.Run(test.name, func(t *testing.T) {
+			c8 := newTestChip8(t, uint8(test.op>>8), uint8(test.op))
+			if test.setup != nil {
+				test.setup(c8)
+			}
+			if err := c8.Step(); err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, c8)
+		})
+	}
+}
+
+func TestSupportedOpcodes(t *testing.T) {
+	opcodes := SupportedOpcodes()
+	if len(opcodes) == 0 {
+		t.Fatal("No supported opcodes")
+	}
+	for _, want := range []string{"CLS", "DRW"} {
+		found := false
+		for _, info := range opcodes {
+			if info.Mnemonic == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s not supported", want)
+		}
+	}
+}
+
+// Every instruction listed by SupportedOpcodes must have a test.
+func TestOpcodesCovered(t *testing.T) {
+	for _, info := range SupportedOpcodes() {
+		covered := false
+		for _, test := range opTests {
+			if test.op&info.mask == info.value {
+				covered = true
+			}
+		}
+		if !covered {
+			t.Errorf("No test for %s", info.Pattern)
+		}
+	}
+}
+
+var quirkOpTests = []struct {
+	name   string
+	quirks Quirks
+	opTest
+}{
+	{"VFReset", Quirks{VFReset: true}, opTest{"8xy1 OR", 0x8121,
+		setV(1, 0x0c, 2, 0x0a, 0xf, 7), checkV(1, 0x0e, 0xf, 0)}},
+	{"no Shift", Quirks{}, opTest{"8xy6 SHR", 0x8126,
+		setV(1, 0xf0, 2, 0x05), checkV(1, 0x02, 0xf, 1)}},
+	{"no Shift", Quirks{}, opTest{"8xyE SHL", 0x812e,
+		setV(1, 0x0f, 2, 0x81), checkV(1, 0x02, 0xf, 1)}},
+	{"Jump", Quirks{Jump: true}, opTest{"Bnnn JP", 0xb310,
+		setV(0, 0x10, 3, 0x20), checkPC(0x330)}},
+	{"Clip", Quirks{Clip: true}, opTest{"Dxyn DRW", 0xd121,
+		func(c8 *Chip8) { c8.i = 0; c8.v[1], c8.v[2] = 62, 31 },
+		func(t *testing.T, c8 *Chip8) {
+			if c8.Gfx[62][31] != 1 || c8.Gfx[0][31] != 0 {
+				t.Error("sprite not clipped")
+			}
+		}}},
+	{"LoadStore", Quirks{LoadStore: true}, opTest{"Fx55 LD [I], Vx", 0xf255,
+		func(c8 *Chip8) { c8.i = 0x300 },
+		func(t *testing.T, c8 *Chip8) { wantI(t, c8, 0x303) }}},
+	{"LoadStore", Quirks{LoadStore: true}, opTest{"Fx65 LD Vx, [I]", 0xf265,
+		func(c8 *Chip8) { c8.i = 0x300 },
+		func(t *testing.T, c8 *Chip8) { wantI(t, c8, 0x303) }}},
+	{"no ClampStartCoord", Quirks{}, opTest{"Dxyn DRW", 0xd121,
+		func(c8 *Chip8) { c8.i = 0; c8.v[1], c8.v[2] = 70, 1 },
+		func(t *testing.T, c8 *Chip8) {
+			if c8.Gfx[6][1] != 1 {
+				t.Error("sprite not drawn at x = 70 % 64")
+			}
+		}}},
+	{"ClampStartCoord", Quirks{ClampStartCoord: true}, opTest{"Dxyn DRW",
+		0xd121, func(c8 *Chip8) { c8.i = 0; c8.v[1], c8.v[2] = 70, 1 },
+		func(t *testing.T, c8 *Chip8) {
+			if c8.Gfx != [DisplayWidth][DisplayHeight]uint8{} {
+				t.Error("sprite at x = 70 drawn")
+			}
+		}}},
+	{"no ResultLast", Quirks{}, opTest{"8xy4 ADD VF, VF", 0x8ff4,
+		setV(0xf, 0x90), checkV(0xf, 1)}},
+	{"ResultLast", Quirks{ResultLast: true}, opTest{"8xy4 ADD VF, VF", 0x8ff4,
+		setV(0xf, 0x90), checkV(0xf, 0x20)}},
+}
+
+func TestQuirks(t *testing.T) {
+	for _, test := range quirkOpTests {
+		t.Run(test.name+" "+test.opTest.name, func(t *testing.T) {
+			c8 := newTestChip8(t, uint8(test.op>>8), uint8(test.op))
+			c8.Quirks = test.quirks
+			if test.setup != nil {
+				test.setup(c8)
+			}
+			if err := c8.Step(); err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, c8)
+		})
+	}
+}
+
+func TestDrawVFCoordinate(t *testing.T) {
+	// DRW VF, V1, 1 drawing its own first byte as the sprite.
+	c8 := newTestChip8(t, 0xdf, 0x11)
+	c8.i = 0x200
+	c8.v[0xf], c8.v[1] = 10, 5
+	if err := c8.Step(); err != nil {
+		t.Fatal(err)
+	}
+	// 0xdf is 11011111.
+	if c8.Gfx[10][5] != 1 || c8.Gfx[12][5] != 0 || c8.Gfx[11][5] != 1 {
+		t.Error("sprite not drawn at x = 10, the value of VF")
+	}
+	wantV(t, c8, 0xf, 0)
+}
+
+func TestExit(t *testing.T) {
+	c8 := newTestChip8(t, 0x00, 0xfd, 0x60, 0x01) // EXIT; LD V0, 1
+	if n, err := c8.StepN(2); n != 1 || err != nil {
+		t.Fatalf("StepN(2) = %d, %v, want 1, nil", n, err)
+	}
+	if n, err := c8.StepN(2); n != 0 || err != nil {
+		t.Errorf("StepN(2) after exiting = %d, %v, want 0, nil", n, err)
+	}
+	if !c8.Halted() {
+		t.Error("not halted")
+	}
+	wantV(t, c8, 0, 0)
+	if drew, err := c8.RunToDraw(10); drew || err != nil {
+		t.Errorf("RunToDraw(10) after exiting = %v, %v, want false, nil",
+			drew, err)
+	}
+	c8.Reset()
+	if c8.Halted() {
+		t.Error("halted after reset")
+	}
+
+	// Exiting in a subroutine.
+	c8 = newTestChip8(t,
+		0x22, 0x04, // CALL 0x204
+		0x00, 0x00,
+		0x00, 0xfd) // 0x204: EXIT
+	if err := c8.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c8.Finish(); !errors.Is(err, ErrProgramExit) {
+		t.Errorf("Finish() = %v, want ErrProgramExit", err)
+	}
+}
+
+func TestUnknownOpcode(t *testing.T) {
+	for _, op := range []uint16{0x5121, 0x812f, 0x9121, 0xe1ff, 0xf1ff} {
+		c8 := newTestChip8(t, uint8(op>>8), uint8(op))
+		if err := c8.Step(); err == nil {
+			t.Errorf("0x%04x: no error", op)
+		}
+	}
+	c8 := newTestChip8(t, 0xf0, 0x00, 0x03, 0x00)
+	if err := c8.Step(); err == nil || !strings.Contains(err.Error(), "XO-CHIP") {
+		t.Errorf("F000: got %v, want an error about XO-CHIP", err)
+	}
+}
+
+func TestPCOutOfBounds(t *testing.T) {
+	c8 := newTestChip8(t, 0x1f, 0xff) // JP 0xfff
+	if err := c8.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c8.Step(); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestWaitingForTimer(t *testing.T) {
+	c8 := newTestChip8(t,
+		0x60, 0x03, // LD V0, 3
+		0xf0, 0x15, // LD DT, V0
+		0xf1, 0x07, // LD V1, DT
+		0x31, 0x00, // SE V1, 0
+		0x12, 0x04, // JP 0x204
+		0x12, 0x0a, // JP 0x20a
+	)
+	if _, err := c8.StepN(2); err != nil {
+		t.Fatal(err)
+	}
+	if !c8.WaitingForTimer() {
+		t.Fatal("spin on DT not detected")
+	}
+	c8.IdleDetection = true
+	r := NewRunner(c8, DefaultSpeed)
+	if err := r.RunFrame(nil); err != nil {
+		t.Fatal(err)
+	}
+	if r.cycles != 3 {
+		t.Errorf("executed %d instructions in the frame, want 3", r.cycles)
+	}
+	for c8.dt > 0 {
+		if err := r.RunFrame(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c8.WaitingForTimer() {
+		t.Error("idle with DT = 0")
+	}
+}
+
+func TestTargets(t *testing.T) {
+	c8 := newTestChip8(t,
+		0x22, 0x0a, // CALL 0x20a
+		0x22, 0x0a, // CALL 0x20a
+		0x12, 0x08, // JP 0x208
+		0xb2, 0x00, // JP V0, 0x200
+		0x12, 0x04, // JP 0x204
+		0x00, 0xee, // RET
+	)
+	check := func(name string, got, want []uint16) {
+		t.Helper()
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s = %x, want %x", name, got, want)
+		}
+	}
+	check("CallTargets", c8.CallTargets(), []uint16{0x20a})
+	check("JumpTargets", c8.JumpTargets(), []uint16{0x204, 0x208})
+	check("DynamicJumps", c8.DynamicJumps(), []uint16{0x206})
+}
+
+func TestStickyKeys(t *testing.T) {
+	c8 := newTestChip8(t)
+	c8.StickyKeys = true
+	c8.SetKey(5, true)
+	c8.SetKey(5, false)
+	if !c8.KeyPressed(5) {
+		t.Fatal("key not latched after release")
+	}
+	c8.SetKey(5, true)
+	c8.SetKey(5, false)
+	if c8.KeyPressed(5) {
+		t.Error("key still latched after second press")
+	}
+}
+
+func TestFrameStream(t *testing.T) {
+	s, err := ListenFrames("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer s.Close()
+	var gfx [DisplayWidth][DisplayHeight]uint8
+	gfx[9][1] = 1
+	s.Send(&gfx)
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var header FrameHeader
+	if err := binary.Read(conn, binary.BigEndian, &header); err != nil {
+		t.Fatal(err)
+	}
+	if string(header.Magic[:]) != "CH8F" || header.Width != DisplayWidth ||
+		header.Height != DisplayHeight || header.Planes != maxPlanes {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	frame := make([]byte, len(PackFrame(&gfx)))
+	if _, err := io.ReadFull(conn, frame); err != nil {
+		t.Fatal(err)
+	}
+	// Pixel (9, 1) is in the second byte of the second row.
+	if frame[DisplayWidth/8+1] != 0x40 {
+		t.Errorf("frame doesn't have pixel (9, 1) set: % x", frame[:16])
+	}
+}
+
+func TestStackContents(t *testing.T) {
+	c8 := newTestChip8(t,
+		0x22, 0x04, // CALL 0x204
+		0x00, 0x00,
+		0x22, 0x08, // CALL 0x208
+		0x00, 0x00,
+		0x00, 0x00,
+	)
+	if _, err := c8.StepN(2); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(c8.StackContents()); got != "[512 516]" {
+		t.Errorf("StackContents() = %s, want [512 516]", got)
+	}
+}
+
+func TestOnFrame(t *testing.T) {
+	c8 := newTestChip8(t, 0x12, 0x00) // JP 0x200
+	frames := 0
+	c8.OnFrame = func(c8 *Chip8) { frames++ }
+	if _, err := NewRunner(c8, DefaultSpeed).RunFor(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if frames != FrameRate {
+		t.Errorf("OnFrame called %d times in a second, want %d",
+			frames, FrameRate)
+	}
+}
+
+func TestWaitingForKey(t *testing.T) {
+	c8 := newTestChip8(t, 0xf3, 0x0a) // LD V3, K
+	if waiting, _ := c8.WaitingForKey(); waiting {
+		t.Error("waiting before executing Fx0A")
+	}
+	if err := c8.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if waiting, reg := c8.WaitingForKey(); !waiting || reg != 3 {
+		t.Errorf("WaitingForKey() = %v, %d, want true, 3", waiting, reg)
+	}
+	c8.SetKey(7, true)
+	c8.SetKey(7, false)
+	if err := c8.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if waiting, _ := c8.WaitingForKey(); waiting {
+		t.Error("waiting after a key")
+	}
+	wantV(t, c8, 3, 7)
+}
+
+func TestRunBatch(t *testing.T) {
+	dir := t.TempDir()
+	roms := map[string][]uint8{
+		"draw.ch8":  {0xd0, 0x15, 0x12, 0x02}, // DRW V0, V1, 5; JP 0x202
+		"error.ch8": {0xff, 0xff},
+		"key.ch8":   {0xf0, 0x0a}, // LD V0, K
+		"loop.ch8":  {0x12, 0x00}, // JP 0x200
+		"panic.ch8": {0x00, 0xee}, // RET with an empty stack
+	}
+	for name, rom := range roms {
+		if err := os.WriteFile(filepath.Join(dir, name), rom, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results, err := RunBatch(dir, 2*time.Second, DefaultSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, res := range results {
+		got = append(got, fmt.Sprintf("%s: %s", res.Name, res.Status))
+	}
+	want := []string{
+		"draw.ch8: ok",
+		"error.ch8: crashed",
+		"key.ch8: hung",
+		"loop.ch8: no draw",
+		"panic.ch8: crashed",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("results = %q, want %q", got, want)
+	}
+}
+
+func TestTimingAccurate(t *testing.T) {
+	perFrame := func(rom ...uint8) int {
+		c8 := newTestChip8(t, rom...)
+		r := NewRunner(c8, DefaultSpeed)
+		r.Timing = TimingAccurate
+		for i := 0; i < 10; i++ {
+			if err := r.RunFrame(nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return r.cycles / 10
+	}
+	draw := perFrame(0xd0, 0x15, 0x12, 0x00) // DRW V0, V1, 5; JP 0x200
+	add := perFrame(0x70, 0x01, 0x12, 0x00)  // ADD V0, 1; JP 0x200
+	if draw >= add {
+		t.Errorf("%d instructions per frame drawing, %d adding, want fewer "+
+			"drawing", draw, add)
+	}
+}
+
+func TestDumpMemory(t *testing.T) {
+	c8 := newTestChip8(t, 0x12, 0x00)
+	c8.Poke(0x202, 0xab)
+	c8.Poke(0xfff, 0xcd)
+	dump := c8.DumpMemory(RomLoadAddr, 0x203)
+	if fmt.Sprintf("% x", dump) != "12 00 ab" {
+		t.Errorf("DumpMemory(0x200, 0x203) = % x, want 12 00 ab", dump)
+	}
+	if dump := c8.DumpMemory(0xffe, 0xffff); len(dump) != 2 || dump[1] != 0xcd {
+		t.Errorf("DumpMemory(0xffe, 0xffff) = % x, want 00 cd", dump)
+	}
+	if dump := c8.DumpMemory(0x300, 0x200); len(dump) != 0 {
+		t.Errorf("DumpMemory(0x300, 0x200) = % x, want empty", dump)
+	}
+}
+
+func TestAutoTune(t *testing.T) {
+	// A host running an instruction in 20µs can run 833 per frame.
+	const perInstruction = 20 * time.Microsecond
+	a := &AutoTune{Target: 2000}
+	cycles := a.Target
+	for i := 0; i < 200; i++ {
+		cycles = a.Update(time.Duration(cycles) * perInstruction)
+	}
+	settled, ok := a.Settled()
+	if !ok {
+		t.Fatalf("not settled, at %d instructions per frame", cycles)
+	}
+	work := time.Duration(settled) * perInstruction
+	if work > tuneSlow || work < tuneFast/2 {
+		t.Errorf("settled at %d instructions per frame, taking %v",
+			settled, work)
+	}
+}
+
+func TestRandomReplay(t *testing.T) {
+	rom := []uint8{0xc0, 0xff, 0xc1, 0xff, 0xc2, 0x0f} // RND V0-V2
+	c8 := newTestChip8(t, rom...)
+	c8.RecordRandom()
+	if _, err := c8.StepN(3); err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	if err := c8.WriteRandomLog(&log); err != nil {
+		t.Fatal(err)
+	}
+	hash, data, err := ReadRandomLog(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != c8.RomHash() {
+		t.Errorf("ROM hash %s, want %s", hash, c8.RomHash())
+	}
+	replay := newTestChip8(t, rom...)
+	replay.SetSeed(2)
+	replay.ReplayRandom(data)
+	if _, err := replay.StepN(3); err != nil {
+		t.Fatal(err)
+	}
+	if replay.v != c8.v {
+		t.Errorf("V = % x after replay, want % x", replay.v, c8.v)
+	}
+}
+
+func TestDisassembleAround(t *testing.T) {
+	c8 := newTestChip8(t, 0x60, 0x01, 0x61, 0x02, 0x62, 0x03, 0x12, 0x06)
+	c8.pc = 0x202
+	got := strings.Join(c8.DisassembleAround(1, 1), "\n")
+	want := strings.Join([]string{
+		"  0x200  6001  LD V0, 0x01",
+		"> 0x202  6102  LD V1, 0x02",
+		"  0x204  6203  LD V2, 0x03",
+	}, "\n")
+	if got != want {
+		t.Errorf("DisassembleAround(1, 1) =\n%s\nwant\n%s", got, want)
+	}
+	c8.pc = 0xffe
+	if n := len(c8.DisassembleAround(1, 3)); n != 2 {
+		t.Errorf("%d lines at the end of memory, want 2", n)
+	}
+}
+
+func TestTraceStates(t *testing.T) {
+	rom := []uint8{
+		0xc0, 0xff, // RND V0, 0xff
+		0xf0, 0x15, // LD DT, V0
+		0x71, 0x01, // ADD V1, 1
+		0x12, 0x00, // JP 0x200
+	}
+	trace := func(inject func(c8 *Chip8)) StateTrace {
+		c8 := newTestChip8(t, rom...)
+		c8.OnFrame = inject
+		trace, err := NewRunner(c8, DefaultSpeed).TraceStates(20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return trace
+	}
+	golden := trace(nil)
+	if frame := golden.FirstDivergence(trace(nil)); frame != -1 {
+		t.Errorf("deterministic run diverged at frame %d", frame)
+	}
+	injected := trace(func(c8 *Chip8) {
+		if c8.Frame() == 8 {
+			c8.Poke(0x300, 1)
+		}
+	})
+	if frame := golden.FirstDivergence(injected); frame != 7 {
+		t.Errorf("injected change found at frame %d, want 7", frame)
+	}
+}
+
+func TestPastEnd(t *testing.T) {
+	c8 := newTestChip8(t, 0x60, 0x01) // LD V0, 1
+	var warnings []string
+	c8.Warn = func(msg string) { warnings = append(warnings, msg) }
+	if _, err := c8.StepN(3); err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "past the end") {
+		t.Errorf("warnings = %q, want one about running past the end",
+			warnings)
+	}
+	c8.Reset()
+	c8.PastEnd = PastEndHalt
+	if _, err := c8.StepN(3); err != nil {
+		t.Fatal(err)
+	}
+	if !c8.Halted() {
+		t.Error("not halted past the end")
+	}
+	wantPC(t, c8, 0x202)
+}
+
+func TestWaitKeyFreezesTimers(t *testing.T) {
+	for _, freeze := range []bool{false, true} {
+		c8 := newTestChip8(t, 0xf3, 0x0a) // LD V3, K
+		c8.Quirks.WaitKeyFreezesTimers = freeze
+		c8.dt, c8.st = 10, 10
+		if _, err := c8.StepNTimed(4, 1); err != nil {
+			t.Fatal(err)
+		}
+		want := uint8(6)
+		if freeze {
+			want = 10
+		}
+		if c8.dt != want || c8.st != want {
+			t.Errorf("freeze %v: dt, st = %d, %d after 4 frames of waiting, "+
+				"want %d", freeze, c8.dt, c8.st, want)
+		}
+		// The timers count down again once a key ends the wait.
+		c8.SetKey(7, true)
+		c8.SetKey(7, false)
+		if _, err := c8.StepNTimed(2, 1); err != nil {
+			t.Fatal(err)
+		}
+		if c8.dt != want-2 {
+			t.Errorf("freeze %v: dt = %d after the wait, want %d",
+				freeze, c8.dt, want-2)
+		}
+	}
+}
+
+func TestFontDisplay(t *testing.T) {
+	c8 := newTestChip8(t)
+	gfx := c8.FontDisplay()
+	// The glyph for 0 is at the top left of the grid.
+	x0, y0 := 2, (DisplayHeight-2*DisplayWidth/8)/2+1
+	for row, want := range []uint8{0xf0, 0x90, 0x90, 0x90, 0xf0} {
+		var got uint8
+		for col := 0; col < 8; col++ {
+			got |= gfx[x0+col][y0+row] << (7 - col)
+		}
+		if got != want {
+			t.Errorf("row %d of glyph 0 = %02x, want %02x", row, got, want)
+		}
+	}
+	// A font changed in memory is shown as changed.
+	c8.Poke(0, 0xff)
+	gfx = c8.FontDisplay()
+	if gfx[x0+4][y0] != 1 {
+		t.Error("changed glyph not shown")
+	}
+}
+
+func TestLookupProfile(t *testing.T) {
+	c8 := New()
+	if err := c8.LoadRom("testdata/quirks.ch8"); err != nil {
+		t.Fatal(err)
+	}
+	name, ok := LookupProfile(c8.RomHash())
+	if !ok || name != "vip" {
+		t.Fatalf("LookupProfile() = %q, %v, want vip, true", name, ok)
+	}
+	want := Quirks{VFReset: true, LoadStore: true, Clip: true}
+	if Profiles[name] != want {
+		t.Errorf("quirks = %+v, want %+v", Profiles[name], want)
+	}
+	if _, ok := LookupProfile(newTestChip8(t, 0x12, 0x00).RomHash()); ok {
+		t.Error("profile found for an unknown ROM")
+	}
+}
+
+// Assembles a quirks test ROM running checks, see quirkCheck.
+func quirksTestRom(checks []quirkCheck) []uint8 {
+	var rom []uint8
+	for n, check := range checks {
+		rom = append(rom, check.program...)
+		rom = append(rom, 0x80|uint8(3+n), uint8(check.reg)<<4) // LD Vn, Vreg
+	}
+	rom = append(rom, 0x00, 0xe0, 0x6b, 0x00) // CLS, LD VB, 0
+	for n := range checks {
+		rom = append(rom,
+			0xf0|uint8(3+n), 0x29, // LD F, Vn
+			0x6a, uint8(8*n), // LD VA, 8n
+			0xda, 0xb5) // DRW VA, VB, 5
+	}
+	end := RomLoadAddr + uint16(len(rom))
+	return append(rom, 0x10|uint8(end>>8), uint8(end)) // JP end
+}
+
+func TestQuirkChecks(t *testing.T) {
+	for _, check := range vipQuirkChecks {
+		for _, enabled := range []bool{false, true} {
+			c8 := newTestChip8(t, check.program...)
+			*c8.Quirks.byName()[check.quirk] = enabled
+			for c8.pc < RomLoadAddr+uint16(len(check.program)) {
+				if err := c8.Step(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := check.off
+			if enabled {
+				want = check.on
+			}
+			if c8.v[check.reg] != want {
+				t.Errorf("%s quirk %v: V%X = %d, want %d", check.quirk,
+					enabled, check.reg, c8.v[check.reg], want)
+			}
+		}
+	}
+}
+
+func TestDiagnoseQuirks(t *testing.T) {
+	rom, err := os.ReadFile("testdata/quirks.ch8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rom, quirksTestRom(vipQuirkChecks)) {
+		t.Fatal("testdata/quirks.ch8 doesn't match its checks")
+	}
+	tests := []struct {
+		name   string
+		change func(q *Quirks)
+		want   []string
+	}{
+		{"vip", func(q *Quirks) {}, nil},
+		{"shift", func(q *Quirks) { q.Shift = true },
+			[]string{"shift quirk appears wrong"}},
+		{"vfreset", func(q *Quirks) { q.VFReset = false },
+			[]string{"vfreset quirk appears wrong"}},
+		{"default", func(q *Quirks) { *q = Profiles[DefaultProfile] },
+			[]string{"clip quirk appears wrong", "vfreset quirk appears wrong",
+				"shift quirk appears wrong", "loadstore quirk appears wrong"}},
+	}
+	for _, test := range tests {
+		c8 := newTestChip8(t, rom...)
+		c8.Quirks = Profiles["vip"]
+		test.change(&c8.Quirks)
+		if got := c8.DiagnoseQuirks(); len(got) != 0 {
+			t.Errorf("%s: diagnosed %q before the results are shown",
+				test.name, got)
+		}
+		if _, err := c8.StepN(len(rom) / 2); err != nil {
+			t.Fatal(err)
+		}
+		got := c8.DiagnoseQuirks()
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: DiagnoseQuirks() = %q, want %q",
+				test.name, got, test.want)
+		}
+	}
+	if got := newTestChip8(t, 0x00, 0xe0).DiagnoseQuirks(); got != nil {
+		t.Errorf("diagnosed %q for an unknown ROM", got)
+	}
+}
+
+func TestWaitKeyFreezesTimersBlocking(t *testing.T) {
+	for _, runner := range []bool{false, true} {
+		for _, freeze := range []bool{false, true} {
+			c8 := newTestChip8(t, 0xf3, 0x0a) // LD V3, K
+			c8.Quirks.WaitKeyFreezesTimers = freeze
+			c8.dt = 30
+			var dt uint8 // When the wait ends
+			c8.AfterCycle = func(c8 *Chip8, op uint16) { dt = c8.dt }
+			// Blocks for 6 frames, then presses a key.
+			waits := 0
+			wait := func() {
+				time.Sleep(frameDuration)
+				if waits++; waits == 6 {
+					c8.SetKey(7, true)
+					c8.SetKey(7, false)
+				}
+			}
+			var err error
+			if runner {
+				err = NewRunner(c8, DefaultSpeed).RunFrame(wait)
+			} else {
+				err = c8.Cycle(wait)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if freeze && dt != 30 {
+				t.Errorf("runner %v: dt = %d after a frozen wait, want 30",
+					runner, dt)
+			}
+			if !freeze && dt > 28 {
+				t.Errorf("runner %v: dt = %d after waiting 6 frames, want "+
+					"it to have counted down", runner, dt)
+			}
+		}
+	}
+}
+
+func TestMaxCatchUp(t *testing.T) {
+	tests := []struct {
+		maxCatchUp int
+		want       int
+	}{
+		{4, 4},
+		{1, 1},
+		{0, 600}, // No limit
+	}
+	for _, test := range tests {
+		r := NewRunner(newTestChip8(t, 0x12, 0x00), DefaultSpeed) // JP 0x200
+		r.MaxCatchUp = test.maxCatchUp
+		// A stall of 10 s.
+		frames, err := r.advance(10*time.Second, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frames != test.want {
+			t.Errorf("MaxCatchUp %d: ran %d frames, want %d",
+				test.maxCatchUp, frames, test.want)
+		}
+		if r.cycles != frames*r.CyclesPerFrame {
+			t.Errorf("MaxCatchUp %d: ran %d cycles, want %d",
+				test.maxCatchUp, r.cycles, frames*r.CyclesPerFrame)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	c8 := newTestChip8(t, 0x22, 0x04, 0x00, 0x00, 0x63, 0x2a) // CALL 0x204
+	c8.i, c8.dt, c8.st = 0x345, 20, 10
+	if _, err := c8.StepN(2); err != nil {
+		t.Fatal(err)
+	}
+	saved := c8.SaveContext()
+	c8.v[3], c8.i, c8.pc, c8.sp, c8.dt, c8.st = 0, 0, 0x300, 0, 0, 0
+	if err := c8.LoadContext(saved); err != nil {
+		t.Fatal(err)
+	}
+	wantV(t, c8, 3, 0x2a)
+	wantI(t, c8, 0x345)
+	wantPC(t, c8, 0x206)
+	if c8.dt != 20 || c8.st != 10 {
+		t.Errorf("DT, ST = %d, %d, want 20, 10", c8.dt, c8.st)
+	}
+	if stack := c8.StackContents(); len(stack) != 1 || stack[0] != 0x200 {
+		t.Errorf("stack = %03x, want [200]", stack)
+	}
+
+	bad := saved
+	bad.SP = 17
+	if err := c8.LoadContext(bad); err == nil {
+		t.Error("no error for SP past the end of the stack")
+	}
+	if c8.SaveContext() != saved {
+		t.Error("context changed by a failed load")
+	}
+}
+
+func TestCompareRuns(t *testing.T) {
+	// Draws the digit in V0 once the delay timer runs out, in frame 3.
+	rom := []uint8{
+		0x60, 0x00, // LD V0, 0
+		0x61, 0x03, // LD V1, 3
+		0xf1, 0x15, // LD DT, V1
+		0xf1, 0x07, // 0x206: LD V1, DT
+		0x31, 0x00, // SE V1, 0
+		0x12, 0x06, // JP 0x206
+		0xf0, 0x29, // LD F, V0
+		0xd2, 0x25, // DRW V2, V2, 5
+		0x12, 0x10, // 0x210: JP 0x210
+	}
+	patched := append([]uint8(nil), rom...)
+	patched[1] = 0x01 // LD V0, 1
+	tests := []struct {
+		name       string
+		romA, romB []uint8
+		wantFrame  int
+		wantOK     bool
+	}{
+		{"same", rom, rom, -1, true},
+		{"patched", rom, patched, 3, false},
+		{"both failing", []uint8{0xff, 0xff}, []uint8{0xff, 0xff}, 0, false},
+	}
+	for _, test := range tests {
+		frame, ok := CompareRuns(test.romA, test.romB, 600)
+		if frame != test.wantFrame || ok != test.wantOK {
+			t.Errorf("%s: CompareRuns() = %d, %v, want %d, %v", test.name,
+				frame, ok, test.wantFrame, test.wantOK)
+		}
+	}
+}
+
+func TestAutoTuneWaitingForKey(t *testing.T) {
+	c8 := newTestChip8(t, 0xf3, 0x0a, 0x12, 0x02) // LD V3, K; JP 0x202
+	r := NewRunner(c8, DefaultSpeed)
+	r.AutoTune = &AutoTune{Target: r.CyclesPerFrame}
+	// Non-blocking, Fx0A is retried all frame.
+	if err := r.RunFrame(nil); err != nil {
+		t.Fatal(err)
+	}
+	// Blocking for 3 frames before a key is pressed.
+	waits := 0
+	err := r.RunFrame(func() {
+		time.Sleep(frameDuration)
+		if waits++; waits == 3 {
+			c8.SetKey(7, true)
+			c8.SetKey(7, false)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.CyclesPerFrame != r.AutoTune.Target {
+		t.Errorf("CyclesPerFrame = %d after waiting for a key, want %d",
+			r.CyclesPerFrame, r.AutoTune.Target)
+	}
+}
+
+func TestStepN(t *testing.T) {
+	c8 := newTestChip8(t,
+		0x60, 0x01, // LD V0, 1
+		0x61, 0x02, // LD V1, 2
+		0xff, 0xff, // Unknown
+		0x62, 0x03, // LD V2, 3
+		0x63, 0x04) // LD V3, 4
+	n, err := c8.StepN(5)
+	if n != 2 || err == nil {
+		t.Fatalf("StepN(5) = %d, %v, want 2 and an error", n, err)
+	}
+	wantV(t, c8, 1, 2)
+	wantV(t, c8, 2, 0)
+	wantPC(t, c8, 0x204)
+}
+
+func TestWarmReset(t *testing.T) {
+	for _, warm := range []bool{false, true} {
+		c8 := newTestChip8(t, 0x60, 0x05, 0xd0, 0x05) // LD V0, 5; DRW V0, V0, 5
+		if _, err := c8.StepN(2); err != nil {
+			t.Fatal(err)
+		}
+		before := c8.FrameHash()
+		c8.Draw = false
+		if warm {
+			c8.WarmReset()
+		} else {
+			c8.Reset()
+		}
+		wantPC(t, c8, 0x200)
+		wantV(t, c8, 0, 0)
+		if c8.Peek(0x202) != 0xd0 {
+			t.Errorf("warm %v: ROM not reloaded", warm)
+		}
+		kept := c8.FrameHash() == before
+		if kept != warm {
+			t.Errorf("warm %v: display kept = %v, want %v", warm, kept, warm)
+		}
+		if c8.Draw == warm {
+			t.Errorf("warm %v: Draw = %v, want %v", warm, c8.Draw, !warm)
+		}
+	}
+}
+
+func TestBugReport(t *testing.T) {
+	c8 := newTestChip8(t, 0x60, 0x05, 0xd0, 0x05, 0xff, 0xff)
+	c8.SetSeed(1234)
+	if _, err := c8.StepN(3); err == nil {
+		t.Fatal("no error from the unknown instruction")
+	}
+	report := c8.BugReport()
+	for _, want := range []string{
+		c8.RomHash(),
+		fmt.Sprintf("%016x", c8.FrameHash()),
+		"Seed: 1234",
+		"> 0x204  ffff",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report doesn't contain %q:\n%s", want, report)
+		}
+	}
+}
+
+func TestQueueKeyEvent(t *testing.T) {
+	c8 := newTestChip8(t, 0x12, 0x00) // JP 0x200
+	c8.QueueKeyEvent(10, 5, true)
+	c8.QueueKeyEvent(12, 5, false)
+	r := NewRunner(c8, DefaultSpeed)
+	for c8.Frame() < 15 {
+		if err := r.RunFrame(nil); err != nil {
+			t.Fatal(err)
+		}
+		frame := c8.Frame()
+		if want := frame >= 10 && frame < 12; c8.KeyPressed(5) != want {
+			t.Errorf("frame %d: key pressed = %v, want %v",
+				frame, c8.KeyPressed(5), want)
+		}
+	}
+}
+
+func TestFinish(t *testing.T) {
+	c8 := newTestChip8(t,
+		0x22, 0x06, // CALL 0x206
+		0x60, 0x01, // LD V0, 1
+		0x12, 0x04, // 0x204: JP 0x204
+		0x61, 0x02, // 0x206: LD V1, 2
+		0x62, 0x03, // LD V2, 3
+		0x00, 0xee) // RET
+	if err := c8.Finish(); err == nil {
+		t.Error("no error finishing outside of a subroutine")
+	}
+	if err := c8.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c8.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	wantPC(t, c8, 0x202)
+	wantV(t, c8, 2, 3)
+	wantV(t, c8, 0, 0)
+
+	// A subroutine that never returns.
+	c8 = newTestChip8(t,
+		0x22, 0x04, // CALL 0x204
+		0x00, 0x00,
+		0x12, 0x04) // 0x204: JP 0x204
+	if err := c8.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c8.Finish(); err == nil {
+		t.Errorf("no error after %d instructions", maxFinishSteps)
+	}
+	wantPC(t, c8, 0x204)
+}
+
+func TestStoppedTimers(t *testing.T) {
+	c8 := newTestChip8(t, 0x12, 0x00) // JP 0x200
+	c8.dt, c8.st = 10, 10
+	r := NewRunner(c8, DefaultSpeed)
+	c8.Stop()
+	for i := 0; i < 5; i++ {
+		if err := r.RunFrame(nil); err != nil {
+			t.Fatal(err)
+		}
+		c8.tick()
+	}
+	if c8.dt != 10 || c8.st != 10 {
+		t.Errorf("DT, ST = %d, %d while stopped, want 10, 10", c8.dt, c8.st)
+	}
+	if c8.SoundActive() {
+		t.Error("sound active while stopped")
+	}
+	c8.Resume()
+	if err := r.RunFrame(nil); err != nil {
+		t.Fatal(err)
+	}
+	if c8.dt != 9 || !c8.SoundActive() {
+		t.Errorf("DT = %d, sound active = %v after resuming, want 9, true",
+			c8.dt, c8.SoundActive())
+	}
+}
+
+func TestMemoryWriteLog(t *testing.T) {
+	c8 := newTestChip8(t,
+		0xa3, 0x00, // LD I, 0x300
+		0x60, 0x0a, // LD V0, 0x0a
+		0x61, 0x0b, // LD V1, 0x0b
+		0xf1, 0x55) // 0x206: LD [I], V1
+	c8.EnableMemoryWriteLog()
+	if _, err := c8.StepN(4); err != nil {
+		t.Fatal(err)
+	}
+	want := []MemWrite{{0x300, 0x0a, 0x206}, {0x301, 0x0b, 0x206}}
+	if got := c8.MemoryWriteLog(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("MemoryWriteLog() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPlaneCollision(t *testing.T) {
+	c8 := newTestChip8(t,
+		0xf1, 0x01, // PLANE 1
+		0x60, 0x00, // LD V0, 0 (I = 0, the top of the 0 glyph)
+		0xd0, 0x01, // DRW V0, V0, 1
+		0xd0, 0x01) // DRW V0, V0, 1
+	c8.Gfx[0][0] = 2 // Set on plane 2 only
+	if _, err := c8.StepN(3); err != nil {
+		t.Fatal(err)
+	}
+	wantV(t, c8, 0xf, 0)
+	if c8.Gfx[0][0] != 3 {
+		t.Errorf("pixel = %d after drawing on plane 1, want 3", c8.Gfx[0][0])
+	}
+	if err := c8.Step(); err != nil {
+		t.Fatal(err)
+	}
+	wantV(t, c8, 0xf, 1)
+	if c8.Gfx[0][0] != 2 {
+		t.Errorf("pixel = %d after erasing on plane 1, want 2", c8.Gfx[0][0])
+	}
+}
+
+func TestWaitKeyHeldBefore(t *testing.T) {
+	c8 := newTestChip8(t, 0xf3, 0x0a) // LD V3, K
+	c8.SetKey(5, true)
+	if err := c8.Step(); err != nil {
+		t.Fatal(err)
+	}
+	c8.SetKey(5, false)
+	if err := c8.Step(); err != nil {
+		t.Fatal(err)
+	}
+	wantPC(t, c8, 0x200) // Still waiting
+	c8.SetKey(5, true)
+	c8.SetKey(5, false)
+	if err := c8.Step(); err != nil {
+		t.Fatal(err)
+	}
+	wantPC(t, c8, 0x202)
+	wantV(t, c8, 3, 5)
+}
+
+func TestQuirksEnable(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    Quirks
+		wantErr bool
+	}{
+		{"shift", Quirks{Shift: true}, false},
+		{"shift,loadstore", Quirks{Shift: true, LoadStore: true}, false},
+		{"vfreset,jump,clip", Quirks{VFReset: true, Jump: true, Clip: true},
+			false},
+		{"shift,bogus", Quirks{}, true},
+		{"", Quirks{}, true},
+	}
+	for _, test := range tests {
+		var q Quirks
+		err := q.Enable(test.list)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Enable(%q) error = %v, want error %v",
+				test.list, err, test.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), "loadstore") {
+			t.Errorf("Enable(%q) error %q doesn't list the valid quirks",
+				test.list, err)
+		}
+		if q != test.want {
+			t.Errorf("Enable(%q) = %+v, want %+v", test.list, q, test.want)
+		}
+	}
+}
+
+func TestDrawSpriteAt(t *testing.T) {
+	c8 := newTestChip8(t)
+	c8.v[0xf] = 7
+	sprite := []byte{0x80, 0x40}
+	if c8.DrawSpriteAt(sprite, 10, 5) {
+		t.Error("collision on an empty display")
+	}
+	for _, p := range []struct {
+		x, y int
+		want uint8
+	}{{10, 5, 1}, {11, 6, 1}, {11, 5, 0}, {10, 6, 0}} {
+		if c8.Gfx[p.x][p.y] != p.want {
+			t.Errorf("pixel (%d, %d) = %d, want %d",
+				p.x, p.y, c8.Gfx[p.x][p.y], p.want)
+		}
+	}
+	if !c8.DrawSpriteAt(sprite, 10, 5) {
+		t.Error("no collision drawing over the sprite")
+	}
+	if c8.Gfx[10][5] != 0 || c8.Gfx[11][6] != 0 {
+		t.Error("sprite not erased by drawing it again")
+	}
+	wantV(t, c8, 0xf, 7)
+}
+
+func TestCheckRegisters(t *testing.T) {
+	tests := []struct {
+		name string
+		rom  []uint8
+		want []string
+	}{
+		{"read", []uint8{
+			0x70, 0x01, // ADD V0, 1
+			0x70, 0x01, // ADD V0, 1
+		}, []string{"0x200: V0 read before being written"}},
+		{"written first", []uint8{
+			0x60, 0x01, // LD V0, 1
+			0x70, 0x01, // ADD V0, 1
+		}, nil},
+		{"copied", []uint8{
+			0x81, 0x20, // LD V1, V2
+			0x83, 0x14, // ADD V3, V1
+		}, []string{"0x200: V2 read before being written",
+			"0x202: V3 read before being written"}},
+	}
+	for _, test := range tests {
+		c8 := newTestChip8(t, test.rom...)
+		c8.CheckRegisters = true
+		var warnings []string
+		c8.Warn = func(msg string) { warnings = append(warnings, msg) }
+		if _, err := c8.StepN(len(test.rom) / 2); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(warnings) != fmt.Sprint(test.want) {
+			t.Errorf("%s: warnings = %q, want %q", test.name, warnings,
+				test.want)
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	c8 := newTestChip8(t,
+		0x22, 0x04, // CALL 0x204
+		0x00, 0x00,
+		0x6a, 0x2b, // 0x204: LD VA, 0x2b
+		0xa3, 0x21, // LD I, 0x321
+		0xfa, 0x15, // LD DT, VA
+		0x60, 0x05, // LD V0, 5
+		0xd0, 0x05) // DRW V0, V0, 5
+	if _, err := c8.StepN(6); err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.MarshalIndent(c8, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "state.json")
+	if *update {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("JSON differs from %s, run with -update if intended:\n%s",
+			golden, got)
+	}
+
+	var state struct {
+		Version int
+		V       []uint8
+		I, PC   uint16
+		SP, DT  uint8
+		Stack   []uint16
+		Display string
+	}
+	if err := json.Unmarshal(got, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != stateVersion || state.V[0xa] != 0x2b ||
+		state.I != 0x321 || state.PC != 0x20e || state.SP != 1 ||
+		state.Stack[0] != 0x200 || state.DT != 0x2b {
+		t.Errorf("unexpected state %+v", state)
+	}
+	if len(state.Display) != 2*DisplayWidth*DisplayHeight {
+		t.Errorf("display of %d hex digits, want %d", len(state.Display),
+			2*DisplayWidth*DisplayHeight)
+	}
+}
+
+func TestAfterCycle(t *testing.T) {
+	c8 := newTestChip8(t,
+		0x60, 0x01, // LD V0, 1
+		0x70, 0x02, // ADD V0, 2
+		0x12, 0x06, // JP 0x206
+		0xff, 0xff) // 0x206: Unknown
+	type call struct {
+		op uint16
+		v0 uint8
+	}
+	var calls []call
+	c8.AfterCycle = func(c8 *Chip8, op uint16) {
+		calls = append(calls, call{op, c8.v[0]})
+	}
+	if _, err := c8.StepN(4); err == nil {
+		t.Fatal("no error from the unknown instruction")
+	}
+	want := []call{{0x6001, 1}, {0x7002, 3}, {0x1206, 3}}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("AfterCycle calls = %x, want %x", calls, want)
+	}
+}
+
+func TestInputTimeout(t *testing.T) {
+	// Blocking, waitForInput returns without a key every time.
+	c8 := newTestChip8(t, 0xf3, 0x0a) // LD V3, K
+	c8.InputTimeout = 100
+	done := make(chan error, 1)
+	go func() { done <- c8.Cycle(func() {}) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrInputTimeout) {
+			t.Errorf("Cycle() = %v, want ErrInputTimeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Fx0A still waiting after 5 s")
+	}
+
+	// Not blocking, Fx0A is retried by each step.
+	c8 = newTestChip8(t, 0xf3, 0x0a)
+	c8.InputTimeout = 100
+	n, err := c8.StepN(1000)
+	if !errors.Is(err, ErrInputTimeout) || n != 100 {
+		t.Errorf("StepN() = %d, %v, want 100, ErrInputTimeout", n, err)
+	}
+}
+
+func TestSearchMem(t *testing.T) {
+	c8 := newTestChip8(t)
+	c8.Poke(0x300, 0xa5)
+	c8.Poke(0x310, 0xa5)
+	c8.Poke(0x320, 0xa5)
+	tests := []struct {
+		name   string
+		change func()
+		search func() []uint16
+		want   []uint16
+	}{
+		{"search", func() {}, func() []uint16 { return c8.SearchMem(0xa5) },
+			[]uint16{0x300, 0x310, 0x320}},
+		{"unchanged", func() { c8.Poke(0x300, 0xa6) }, c8.SearchUnchanged,
+			[]uint16{0x310, 0x320}},
+		{"changed", func() { c8.Poke(0x320, 0) }, c8.SearchChanged,
+			[]uint16{0x320}},
+		{"changed again", func() { c8.Poke(0x310, 0) }, c8.SearchChanged,
+			nil},
+	}
+	for _, test := range tests {
+		test.change()
+		got := test.search()
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: hits = %x, want %x", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRunFor(t *testing.T) {
+	tests := []struct {
+		d      time.Duration
+		speed  int
+		frames int
+	}{
+		{0, DefaultSpeed, 0},
+		{10 * time.Millisecond, DefaultSpeed, 0},
+		{500 * time.Millisecond, DefaultSpeed, 30},
+		{time.Second, DefaultSpeed, 60},
+		{time.Second, 1200, 60},
+		{2*time.Second + 10*time.Millisecond, DefaultSpeed, 120},
+	}
+	for _, test := range tests {
+		c8 := newTestChip8(t,
+			0x60, 0xff, // LD V0, 0xff
+			0xf0, 0x15, // LD DT, V0
+			0x12, 0x04) // JP 0x204
+		cycles := 0
+		c8.AfterCycle = func(*Chip8, uint16) { cycles++ }
+		r := NewRunner(c8, test.speed)
+		frames, err := r.RunFor(test.d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frames != test.frames || c8.Frame() != uint64(test.frames) {
+			t.Errorf("RunFor(%v) ran %d frames, Frame() = %d, want %d",
+				test.d, frames, c8.Frame(), test.frames)
+		}
+		if want := test.frames * test.speed / FrameRate; cycles != want {
+			t.Errorf("RunFor(%v) at %d: %d cycles, want %d",
+				test.d, test.speed, cycles, want)
+		}
+		if test.frames > 0 && c8.dt != uint8(0xff-test.frames) {
+			t.Errorf("RunFor(%v): DT = %d, want %d",
+				test.d, c8.dt, 0xff-test.frames)
+		}
+	}
+}
+
+func TestHotspots(t *testing.T) {
+	c8 := newTestChip8(t,
+		0x60, 0x00, // LD V0, 0
+		0x71, 0x01, // 0x202: ADD V1, 1
+		0x31, 0x05, // SE V1, 5
+		0x12, 0x02, // JP 0x202
+		0x12, 0x08) // 0x208: JP 0x208
+	if _, err := c8.StepN(1); err != nil {
+		t.Fatal(err)
+	}
+	if hs := c8.Hotspots(); len(hs) != 0 {
+		t.Errorf("Hotspots() = %v without profiling, want none", hs)
+	}
+	c8.EnableProfiling()
+	if _, err := c8.StepN(29); err != nil {
+		t.Fatal(err)
+	}
+	want := []Hotspot{{0x208, 15}, {0x202, 5}, {0x204, 5}, {0x206, 4}}
+	if got := c8.Hotspots(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Hotspots() = %x, want %x", got, want)
+	}
+}
+
+func TestLoadAndDisassemble(t *testing.T) {
+	tests := []struct {
+		name string
+		rom  []byte
+		want []string
+	}{
+		{"empty", nil, nil},
+		{"instructions", []byte{0x00, 0xe0, 0xa2, 0x2a, 0xd0, 0x15}, []string{
+			"0x200  00e0  CLS",
+			"0x202  a22a  LD I, 0x22a",
+			"0x204  d015  DRW V0, V1, 5",
+		}},
+		{"odd length", []byte{0x60, 0x0c, 0xff}, []string{
+			"0x200  600c  LD V0, 0x0c",
+			"0x202  ff    DB 0xff",
+		}},
+	}
+	for _, test := range tests {
+		c8 := New()
+		rom, lines, err := c8.LoadAndDisassemble(bytes.NewReader(test.rom))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !bytes.Equal(rom, test.rom) {
+			t.Errorf("%s: ROM = % x, want % x", test.name, rom, test.rom)
+		}
+		loaded := c8.DumpMemory(RomLoadAddr, RomLoadAddr+uint16(len(rom)))
+		if !bytes.Equal(loaded, rom) {
+			t.Errorf("%s: loaded % x, returned % x", test.name, loaded, rom)
+		}
+		if strings.Join(lines, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s: listing\n%s\nwant\n%s", test.name,
+				strings.Join(lines, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
+
+func TestCheckAlignment(t *testing.T) {
+	tests := []struct {
+		name  string
+		check bool
+		rom   []uint8
+		want  []string
+	}{
+		{"odd jump", true, []uint8{
+			0x12, 0x03, // JP 0x203
+			0x00,
+			0x60, 0x05, // 0x203: LD V0, 5
+		}, []string{"0x203: Instruction fetched at odd address"}},
+		{"unchecked", false, []uint8{0x12, 0x03, 0x00, 0x60, 0x05}, nil},
+		{"even jump", true, []uint8{
+			0x12, 0x04, // JP 0x204
+			0x00, 0x00,
+			0x60, 0x05, // 0x204: LD V0, 5
+		}, nil},
+	}
+	for _, test := range tests {
+		c8 := newTestChip8(t, test.rom...)
+		c8.CheckAlignment = test.check
+		var warnings []string
+		c8.Warn = func(msg string) { warnings = append(warnings, msg) }
+		if _, err := c8.StepN(2); err != nil {
+			t.Fatal(err)
+		}
+		wantV(t, c8, 0, 5)
+		if fmt.Sprint(warnings) != fmt.Sprint(test.want) {
+			t.Errorf("%s: warnings = %q, want %q", test.name, warnings,
+				test.want)
+		}
+	}
+}
+
+func TestPalette(t *testing.T) {
+	black := color.RGBA{0, 0, 0, 255}
+	white := color.RGBA{255, 255, 255, 255}
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	tests := []struct {
+		name    string
+		palette Palette
+		want    [4]color.Color // Colors of the display values 0 to 3
+	}{
+		{"2 colors", Palette{black, white}, [4]color.Color{
+			black, white, white, white}},
+		{"4 colors", Palette{black, white, red, blue}, [4]color.Color{
+			black, white, red, blue}},
+	}
+	var gfx [DisplayWidth][DisplayHeight]uint8
+	for v := range gfx[:4] {
+		gfx[v][0] = uint8(v)
+	}
+	for _, test := range tests {
+		img := test.palette.Image(&gfx)
+		for v, want := range test.want {
+			if got := test.palette[test.palette.Index(uint8(v))]; got != want {
+				t.Errorf("%s: Index(%d) gives %v, want %v",
+					test.name, v, got, want)
+			}
+			if got := img.At(v, 0); got != want {
+				t.Errorf("%s: pixel of value %d is %v, want %v",
+					test.name, v, got, want)
+			}
+		}
+		if got := img.At(10, 10); got != black {
+			t.Errorf("%s: background is %v, want %v", test.name, got, black)
+		}
+	}
+}
+
+func TestRunToDraw(t *testing.T) {
+	rom := []uint8{
+		0x60, 0x01, // LD V0, 1
+		0x61, 0x02, // LD V1, 2
+		0x00, 0xe0, // CLS
+		0x62, 0x03, // LD V2, 3
+		0xd0, 0x15, // DRW V0, V1, 5
+		0x12, 0x0a, // JP 0x20A
+	}
+	tests := []struct {
+		name      string
+		maxCycles []int // Of successive calls
+		drew      bool  // Result of the last call
+		pc        uint16
+	}{
+		{"CLS", []int{10}, true, 0x206},
+		{"CLS at the cap", []int{3}, true, 0x206},
+		{"cap", []int{2}, false, 0x204},
+		{"DRW", []int{10, 10}, true, 0x20a},
+		{"no more draws", []int{10, 10, 10}, false, 0x20a},
+	}
+	for _, test := range tests {
+		c8 := newTestChip8(t, rom...)
+		var drew bool
+		var err error
+		for _, n := range test.maxCycles {
+			if drew, err = c8.RunToDraw(n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if drew != test.drew || c8.pc != test.pc {
+			t.Errorf("%s: drew = %v, PC = 0x%03x, want %v, 0x%03x",
+				test.name, drew, c8.pc, test.drew, test.pc)
+		}
+	}
+}
+
+func TestReservedWrite(t *testing.T) {
+	rom := []uint8{
+		0xa1, 0xff, // LD I, 0x1FF
+		0x60, 0xaa, // LD V0, 0xAA
+		0x61, 0xbb, // LD V1, 0xBB
+		0xf1, 0x55, // LD [I], V1
+	}
+	warning := "0x206: Write to reserved memory at 0x1ff (I=0x1ff)"
+	tests := []struct {
+		policy ReservedPolicy
+		want   []string
+		mem    uint8 // At 0x1FF
+	}{
+		{ReservedWarn, []string{warning}, 0xaa},
+		{ReservedAllow, nil, 0xaa},
+		{ReservedProtect, []string{warning}, 0},
+	}
+	for _, test := range tests {
+		c8 := newTestChip8(t, rom...)
+		c8.Reserved = test.policy
+		var warnings []string
+		c8.Warn = func(msg string) { warnings = append(warnings, msg) }
+		if _, err := c8.StepN(4); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(warnings) != fmt.Sprint(test.want) {
+			t.Errorf("policy %d: warnings = %q, want %q", test.policy,
+				warnings, test.want)
+		}
+		if c8.Peek(0x1ff) != test.mem || c8.Peek(0x200) != 0xbb {
+			t.Errorf("policy %d: memory = %02x %02x, want %02x bb",
+				test.policy, c8.Peek(0x1ff), c8.Peek(0x200), test.mem)
+		}
+	}
+}
+
+func TestTickTimerManual(t *testing.T) {
+	c8 := newTestChip8(t, 0x12, 0x00) // JP 0x200
+	c8.dt, c8.st = 3, 1
+	c8.Stop()
+	tests := []struct{ dt, st uint8 }{
+		{2, 0},
+		{1, 0},
+		{0, 0},
+		{0, 0}, // Stay at 0
+	}
+	for i, test := range tests {
+		c8.TickTimerManual()
+		if c8.dt != test.dt || c8.st != test.st {
+			t.Errorf("tick %d: DT = %d, ST = %d, want %d, %d",
+				i+1, c8.dt, c8.st, test.dt, test.st)
+		}
+	}
+	if c8.Frame() != 0 {
+		t.Errorf("Frame() = %d, want 0", c8.Frame())
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	c8 := newTestChip8(t,
+		0x60, 0x02, // LD V0, 2
+		0x61, 0x03, // LD V1, 3
+		0xd0, 0x15) // DRW V0, V1, 5 (the 0 glyph, I is 0)
+	if _, err := c8.StepN(3); err != nil {
+		t.Fatal(err)
+	}
+	display := c8.Display()
+	if len(display) != DisplayWidth {
+		t.Fatalf("width = %d, want %d", len(display), DisplayWidth)
+	}
+	for x := range display {
+		if len(display[x]) != DisplayHeight {
+			t.Fatalf("height of column %d = %d, want %d",
+				x, len(display[x]), DisplayHeight)
+		}
+	}
+	tests := []struct {
+		x, y int
+		want uint8
+	}{
+		{2, 3, 1}, {5, 3, 1}, {3, 4, 0}, {2, 7, 1}, {6, 3, 0}, {0, 0, 0},
+	}
+	for _, test := range tests {
+		if got := display[test.x][test.y]; got != test.want {
+			t.Errorf("display[%d][%d] = %d, want %d",
+				test.x, test.y, got, test.want)
+		}
+	}
+	display[0][0] = 1
+	if c8.Gfx[0][0] != 0 {
+		t.Error("changing the copy changed Gfx")
+	}
+}
+
+func TestLoadInterpreter(t *testing.T) {
+	c8 := newTestChip8(t, 0x12, 0x00) // JP 0x200
+	if c8.Peek(0x1f0) != 0 {
+		t.Errorf("Peek(0x1f0) = 0x%02x before loading, want 0", c8.Peek(0x1f0))
+	}
+	image := bytes.Repeat([]uint8{0xa5}, int(RomLoadAddr))
+	if err := c8.LoadInterpreter(image); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr uint16
+		want uint8
+	}{
+		{0x000, fontset[0]},
+		{uint16(len(fontset) - 1), fontset[len(fontset)-1]},
+		{uint16(len(fontset)), 0xa5},
+		{0x1ff, 0xa5},
+		{0x200, 0x12},
+	}
+	for _, reset := range []bool{false, true} {
+		if reset {
+			c8.Reset()
+		}
+		for _, test := range tests {
+			if got := c8.Peek(test.addr); got != test.want {
+				t.Errorf("reset %v: Peek(0x%03x) = 0x%02x, want 0x%02x",
+					reset, test.addr, got, test.want)
+			}
+		}
+	}
+	if err := c8.LoadInterpreter(make([]uint8, RomLoadAddr+1)); err == nil {
+		t.Error("no error loading an image overlapping the ROM")
+	}
+}
+
+func TestStepNTimedAfterBreakpoint(t *testing.T) {
+	c8 := newTestChip8(t,
+		0x60, 0x0a, // LD V0, 10
+		0xf0, 0x15, // LD DT, V0
+		0x61, 0x01, // 0x204: LD V1, 1
+		0x12, 0x06) // 0x206: JP 0x206
+	c8.SetBreakpoint(0x204)
+	n, err := c8.StepNTimed(10, 1)
+	if n != 2 || !errors.Is(err, ErrBreakpoint) || !c8.Stopped() {
+		t.Fatalf("StepNTimed(10, 1) = %d, %v, stopped %v, want 2 at the "+
+			"breakpoint", n, err, c8.Stopped())
+	}
+	if c8.dt != 9 {
+		t.Errorf("DT = %d at the breakpoint, want 9", c8.dt)
+	}
+	frame := c8.Frame()
+	if n, err := c8.StepNTimed(6, 2); n != 6 || err != nil {
+		t.Fatalf("StepNTimed(6, 2) = %d, %v, want 6, nil", n, err)
+	}
+	if c8.dt != 6 || c8.Frame() != frame+3 {
+		t.Errorf("DT = %d, %d frames while stopped, want 6, 3", c8.dt,
+			c8.Frame()-frame)
+	}
+}
+
+func TestTearing(t *testing.T) {
+	rom := []byte{
+		0x61, 0x0c, // LD V1, 12
+		0xa2, 0x08, // LD I, 0x208
+		0xd0, 0x18, // DRW V0, V1, 8, rows 12 to 19 while the beam is at 16
+		0x12, 0x06, // JP 0x206
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	}
+	for _, tearing := range []bool{false, true} {
+		r := NewRunner(newTestChip8(t, rom...), DefaultSpeed)
+		r.CyclesPerFrame, r.Tearing = 4, tearing
+		if err := r.RunFrame(nil); err != nil {
+			t.Fatal(err)
+		}
+		gfx := r.C8.Gfx
+		if !tearing {
+			if r.Display != gfx {
+				t.Error("Display differs from Gfx at the end of a frame " +
+					"without tearing")
+			}
+			continue
+		}
+		for y := 12; y < 20; y++ {
+			want := gfx[0][y]
+			if y < 16 {
+				want = 0 // Above the beam, still the previous frame
+			}
+			if r.Display[0][y] != want {
+				t.Errorf("Tearing: row %d is %d, want %d", y,
+					r.Display[0][y], want)
+			}
+		}
+	}
+}
+
+func TestCheckSprites(t *testing.T) {
+	tests := []struct {
+		name string
+		rom  []byte
+		want []string
+	}{
+		{"past end", []byte{
+			0xaf, 0xfe, // LD I, 0xffe
+			0xd0, 0x05, // DRW V0, V0, 5
+		}, []string{"0x202: DRW reads sprite past end of memory " +
+			"(I=0xffe, n=5)"}},
+		{"in range", []byte{
+			0xaf, 0xfb, // LD I, 0xffb
+			0xd0, 0x05, // DRW V0, V0, 5
+		}, nil},
+	}
+	for _, test := range tests {
+		c8 := newTestChip8(t, test.rom...)
+		c8.CheckSprites = true
+		var warnings []string
+		c8.Warn = func(msg string) { warnings = append(warnings, msg) }
+		if _, err := c8.StepN(len(test.rom) / 2); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(warnings) != fmt.Sprint(test.want) {
+			t.Errorf("%s: warnings = %q, want %q", test.name, warnings,
+				test.want)
+		}
+	}
+}
+
+func TestTimerValues(t *testing.T) {
+	c8 := newTestChip8(t,
+		0x60, 0x2a, // LD V0, 42
+		0x61, 0x07, // LD V1, 7
+		0xf0, 0x15, // LD DT, V0
+		0xf1, 0x18) // LD ST, V1
+	if _, err := c8.StepN(4); err != nil {
+		t.Fatal(err)
+	}
+	if dt, st := c8.DelayTimerValue(), c8.SoundTimerValue(); dt != 42 ||
+		st != 7 {
+		t.Errorf("DelayTimerValue() = %d, SoundTimerValue() = %d, want 42, 7",
+			dt, st)
+	}
+}
+
+func TestRunnerCycles(t *testing.T) {
+	c8 := newTestChip8(t,
+		0x60, 0x3c, // LD V0, 60
+		0xf0, 0x15, // LD DT, V0
+		0xf1, 0x07, // 0x204: LD V1, DT
+		0x31, 0x00, // SE V1, 0
+		0x12, 0x04, // JP 0x204
+		0x12, 0x0a) // JP 0x20a
+	c8.IdleDetection = true
+	r := NewRunner(c8, DefaultSpeed)
+	frames, err := r.RunFor(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.MeasuredSpeed()
+	// The first frame stops at the loop, the others run it once.
+	if want := 2 + (frames-1)*3; r.Cycles() != want {
+		t.Errorf("Cycles() = %d after %d idle frames, want %d", r.Cycles(),
+			frames, want)
+	}
+}
+
+func TestDrawOR(t *testing.T) {
+	tests := []struct {
+		mode DrawMode
+		want uint8 // The pixel drawn over
+	}{
+		{DrawXOR, 0},
+		{DrawOR, 1},
+	}
+	for _, test := range tests {
+		c8 := newTestChip8(t, 0xd0, 0x01) // DRW V0, V0, 1
+		c8.DrawMode = test.mode
+		c8.Gfx[0][0] = 1
+		if err := c8.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if c8.Gfx[0][0] != test.want {
+			t.Errorf("DrawMode %d: pixel %d, want %d", test.mode,
+				c8.Gfx[0][0], test.want)
+		}
+		wantV(t, c8, 0xf, 1)
+	}
+}
+
+func TestDrawZeroRows(t *testing.T) {
+	for _, mode := range []DrawMode{DrawXOR, DrawOR} {
+		c8 := newTestChip8(t, 0xd1, 0x20) // DRW V1, V2, 0
+		c8.DrawMode = mode
+		c8.i, c8.v[0xf] = 0x200, 7
+		if err := c8.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if c8.Gfx != [DisplayWidth][DisplayHeight]uint8{} {
+			t.Errorf("DrawMode %d: display changed", mode)
+		}
+		wantV(t, c8, 0xf, 7)
+		wantI(t, c8, 0x200)
+		wantPC(t, c8, 0x202)
+	}
+}
+
+func TestSys(t *testing.T) {
+	c8 := newTestChip8(t, 0x01, 0x23) // SYS 0x123
+	if err := c8.Step(); err != nil {
+		t.Errorf("SysIgnore: %v", err)
+	}
+	wantPC(t, c8, 0x202)
+
+	c8 = newTestChip8(t, 0x01, 0x23)
+	c8.Sys = SysError
+	if err := c8.Step(); err == nil {
+		t.Error("SysError: no error")
+	}
+
+	c8 = newTestChip8(t, 0x01, 0x23)
+	var called uint16
+	c8.Sys = SysCallback
+	c8.OnSys = func(addr uint16) { called = addr }
+	if err := c8.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if called != 0x123 {
+		t.Errorf("OnSys called with 0x%03x, want 0x123", called)
+	}
+	wantPC(t, c8, 0x202)
+}
+
+func TestSelfTest(t *testing.T) {
+	results := SelfTest()
+	if len(results) == 0 {
+		t.Fatal("No self tests")
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Name, r.Err)
+		}
+	}
+}
+
+func TestLoadRomDataSize(t *testing.T) {
+	c8 := New()
+	rom := make([]uint8, MaxRomSize)
+	rom[0], rom[len(rom)-1] = 0x12, 0x34
+	if err := c8.LoadRomData(rom); err != nil {
+		t.Errorf("ROM of max size: %v", err)
+	}
+	if c8.Peek(RomLoadAddr) != 0x12 || c8.Peek(0xfff) != 0x34 {
+		t.Errorf("ROM not loaded at 0x%03x", RomLoadAddr)
+	}
+	if err := c8.LoadRomData(make([]uint8, MaxRomSize+1)); err == nil {
+		t.Error("ROM too big: no error")
+	}
+	if c8.RomSize() != MaxRomSize {
+		t.Errorf("RomSize() = %d, want %d", c8.RomSize(), MaxRomSize)
+	}
+}
+
+func TestConcurrentExecution(t *testing.T) {
+	c8 := newTestChip8(t, 0xf0, 0x0a, 0x12, 0x02) // LD V0, K; JP 0x202
+	waiting, resume := make(chan bool), make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- c8.Cycle(func() {
+			waiting <- true
+			<-resume
+			c8.SetKey(1, true)
+			c8.SetKey(1, false)
+		})
+	}()
+	<-waiting
+	if err := c8.Step(); !errors.Is(err, ErrConcurrentExecution) {
+		t.Errorf("Step() = %v, want ErrConcurrentExecution", err)
+	}
+	resume <- true
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := c8.Step(); err != nil {
+		t.Errorf("Step() after Cycle returned = %v, want nil", err)
+	}
+}
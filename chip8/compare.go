@@ -0,0 +1,68 @@
+package chip8
+
+import "hash/fnv"
+
+// CompareRuns runs two ROMs side by side for the given number of cycles and
+// returns the first frame where their displays differ. Both run headless at
+// DefaultSpeed with the same seed and no input, so any divergence is caused by
+// the ROMs themselves. ok is false if the runs diverge or an instruction fails
+// in either, which counts as diverging at the frame it fails in, or if a ROM
+// fails to load, in which case firstDivergenceFrame is 0.
+func CompareRuns(romA, romB []byte, cycles int) (firstDivergenceFrame int, ok bool) {
+	a, b := New(), New()
+	if a.LoadRomData(romA) != nil || b.LoadRomData(romB) != nil {
+		return 0, false
+	}
+	a.SetSeed(1)
+	b.SetSeed(1)
+	ra, rb := NewRunner(a, DefaultSpeed), NewRunner(b, DefaultSpeed)
+	for frame := 0; frame*ra.CyclesPerFrame < cycles; frame++ {
+		errA, errB := ra.RunFrame(nil), rb.RunFrame(nil)
+		if errA != nil || errB != nil || a.FrameHash() != b.FrameHash() {
+			return frame, false
+		}
+	}
+	return -1, true
+}
+
+// StateHash returns a hash of the machine state as represented by
+// MarshalJSON, for cheaply comparing states.
+func (c8 *Chip8) StateHash() uint64 {
+	state, _ := c8.MarshalJSON()
+	h := fnv.New64a()
+	h.Write(state)
+	return h.Sum64()
+}
+
+// StateTrace holds the StateHash at the end of each frame of a run.
+type StateTrace []uint64
+
+// TraceStates runs the given number of frames without input and returns the
+// trace of their states. The trace is cut short if an instruction fails.
+func (r *Runner) TraceStates(frames int) (StateTrace, error) {
+	var trace StateTrace
+	for i := 0; i < frames; i++ {
+		if err := r.RunFrame(nil); err != nil {
+			return trace, err
+		}
+		trace = append(trace, r.C8.StateHash())
+	}
+	return trace, nil
+}
+
+// FirstDivergence returns the first frame at which the traces differ, or -1
+// if they agree. A run that reproduces a known good one, from the same ROM,
+// seed and input, must not diverge from it: divergence means nondeterminism,
+// e.g. a global random source or map iteration order. A trace that is cut
+// short diverges where it ends.
+func (t StateTrace) FirstDivergence(other StateTrace) int {
+	for i := range t {
+		if i >= len(other) || t[i] != other[i] {
+			return i
+		}
+	}
+	if len(other) > len(t) {
+		return len(t)
+	}
+	return -1
+}
@@ -0,0 +1,295 @@
+package chip8
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrBreakpoint is returned when execution stops at a breakpoint.
+var ErrBreakpoint = errors.New("Stopped at breakpoint")
+
+// ErrProgramExit is returned by Finish when the program exits with 00FD.
+var ErrProgramExit = errors.New("Program exited")
+
+// SetBreakpoint makes stepping stop before executing the instruction at addr.
+func (c8 *Chip8) SetBreakpoint(addr uint16) {
+	if c8.breakpoints == nil {
+		c8.breakpoints = make(map[uint16]bool)
+	}
+	c8.breakpoints[addr] = true
+}
+
+func (c8 *Chip8) ClearBreakpoint(addr uint16) {
+	delete(c8.breakpoints, addr)
+}
+
+// Stop halts emulation by Cycle and Runner, as when stopped in a debugger.
+// The timers are frozen and sound is silenced until Resume is called.
+// Instructions can still be executed with Step.
+func (c8 *Chip8) Stop() {
+	c8.stopped = true
+}
+
+func (c8 *Chip8) Resume() {
+	c8.stopped = false
+}
+
+func (c8 *Chip8) Stopped() bool {
+	return c8.stopped
+}
+
+// Step executes a single instruction without ticking the timers. Fx0A does
+// not block: if no key is pressed the instruction is executed again by the
+// next step.
+func (c8 *Chip8) Step() error {
+	return c8.exec(nil)
+}
+
+// TickTimerManual decrements the delay and sound timers once, as at the end of
+// a frame, also when stopped. Together with Step this allows studying timing
+// dependent code while stopped.
+func (c8 *Chip8) TickTimerManual() {
+	c8.tickTimers()
+}
+
+// StepN executes up to n instructions and returns the number executed. It
+// stops early if an instruction fails, once the program exited with 00FD (see
+// Halted), or with ErrBreakpoint before an instruction at a breakpoint, which
+// also stops emulation (see Stop). A breakpoint at the first instruction is
+// ignored, so that stepping can continue from it.
+func (c8 *Chip8) StepN(n int) (int, error) {
+	return c8.stepN(n, 0)
+}
+
+// StepNTimed is like StepN but also ends a frame, ticking the timers, after
+// every cyclesPerFrame instructions, also while stopped at a breakpoint.
+func (c8 *Chip8) StepNTimed(n, cyclesPerFrame int) (int, error) {
+	return c8.stepN(n, cyclesPerFrame)
+}
+
+func (c8 *Chip8) stepN(n, cyclesPerFrame int) (int, error) {
+	for i := 0; i < n; i++ {
+		if c8.halted {
+			return i, nil
+		}
+		if i > 0 && c8.breakpoints[c8.pc] {
+			c8.Stop()
+			return i, ErrBreakpoint
+		}
+		if err := c8.Step(); err != nil {
+			return i, err
+		}
+		if cyclesPerFrame > 0 && (i+1)%cyclesPerFrame == 0 {
+			c8.endFrame()
+		}
+	}
+	return n, nil
+}
+
+// RunToDraw steps until an instruction updates the display (DRW or CLS), at
+// most maxCycles instructions, and reports whether one did. The drawing
+// instruction is the last one executed. It stops early once the program
+// exited.
+func (c8 *Chip8) RunToDraw(maxCycles int) (drew bool, err error) {
+	for i := 0; i < maxCycles && !c8.halted; i++ {
+		if err := c8.Step(); err != nil {
+			return false, err
+		}
+		if c8.Draw {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CPUContext is the state of the registers, stack and timers.
+type CPUContext struct {
+	V      [0x10]uint8
+	I, PC  uint16
+	SP     uint8
+	Stack  [0x10]uint16
+	DT, ST uint8
+}
+
+// SaveContext returns the state of the registers, stack and timers.
+func (c8 *Chip8) SaveContext() CPUContext {
+	return CPUContext{c8.v, c8.i, c8.pc, c8.sp, c8.stack, c8.dt, c8.st}
+}
+
+// LoadContext sets the registers, stack and timers, leaving memory and the
+// display as they are. Nothing is set if SP is past the end of the stack.
+func (c8 *Chip8) LoadContext(ctx CPUContext) error {
+	if int(ctx.SP) > len(ctx.Stack) {
+		return fmt.Errorf("Stack pointer %d out of range, max is %d", ctx.SP,
+			len(ctx.Stack))
+	}
+	c8.v, c8.i, c8.pc, c8.sp = ctx.V, ctx.I, ctx.PC, ctx.SP
+	c8.stack, c8.dt, c8.st = ctx.Stack, ctx.DT, ctx.ST
+	return nil
+}
+
+// StackContents returns the return addresses on the call stack, the
+// outermost first. Each is the address of a CALL.
+func (c8 *Chip8) StackContents() []uint16 {
+	return append([]uint16(nil), c8.stack[:c8.sp]...)
+}
+
+// Peek returns the byte of memory at addr.
+func (c8 *Chip8) Peek(addr uint16) uint8 {
+	return c8.mem[addr%uint16(len(c8.mem))]
+}
+
+// Poke sets the byte of memory at addr.
+func (c8 *Chip8) Poke(addr uint16, value uint8) {
+	c8.mem[addr%uint16(len(c8.mem))] = value
+}
+
+// DumpMemory returns a copy of memory from start up to end, excluding end,
+// clamped to the memory size. It is empty if start isn't before end. Dumping
+// from RomLoadAddr gives a loadable ROM, e.g. of a program that modified
+// itself.
+func (c8 *Chip8) DumpMemory(start, end uint16) []byte {
+	if int(end) > len(c8.mem) {
+		end = uint16(len(c8.mem))
+	}
+	if start >= end {
+		return []byte{}
+	}
+	return append([]byte(nil), c8.mem[start:end]...)
+}
+
+// MemWrite is a write to memory by the program.
+type MemWrite struct {
+	Addr  uint16
+	Value uint8
+	PC    uint16 // Address of the instruction that wrote
+}
+
+// EnableMemoryWriteLog starts logging all writes by the program, e.g. to study
+// self-modifying code. Writes to reserved memory are logged even if ignored,
+// see ReservedPolicy.
+func (c8 *Chip8) EnableMemoryWriteLog() {
+	c8.logWrites = true
+}
+
+// MemoryWriteLog returns the writes logged since the log was enabled.
+func (c8 *Chip8) MemoryWriteLog() []MemWrite {
+	return append([]MemWrite(nil), c8.writeLog...)
+}
+
+// Maximum number of instructions executed by Finish.
+const maxFinishSteps = 1 << 20
+
+// Finish runs until the current subroutine returns, stopping at the
+// instruction after its CALL. Like StepN it stops early on errors and
+// breakpoints, and with ErrProgramExit once the program exited.
+func (c8 *Chip8) Finish() error {
+	if c8.sp == 0 {
+		return errors.New("Not in a subroutine")
+	}
+	depth := c8.sp
+	for i := 0; i < maxFinishSteps; i++ {
+		if c8.halted {
+			return ErrProgramExit
+		}
+		if i > 0 && c8.breakpoints[c8.pc] {
+			c8.Stop()
+			return ErrBreakpoint
+		}
+		if err := c8.Step(); err != nil {
+			return err
+		}
+		if c8.sp < depth {
+			return nil
+		}
+	}
+	return fmt.Errorf(
+		"Subroutine did not return within %d instructions", maxFinishSteps)
+}
+
+// Number of recently executed instructions kept for debugging.
+const traceSize = 32
+
+type traceEntry struct {
+	pc, op uint16
+}
+
+// RomHash returns the hex encoded SHA-256 hash of the loaded ROM.
+func (c8 *Chip8) RomHash() string {
+	return fmt.Sprintf("%x", sha256.Sum256(c8.rom))
+}
+
+// DumpState returns a description of the registers, timers and stack.
+func (c8 *Chip8) DumpState() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "PC=0x%03x I=0x%03x SP=%d DT=%d ST=%d\n",
+		c8.pc, c8.i, c8.sp, c8.dt, c8.st)
+	for i, x := range c8.v {
+		sep := " "
+		if i%8 == 7 {
+			sep = "\n"
+		}
+		fmt.Fprintf(&b, "V%X=0x%02x%s", i, x, sep)
+	}
+	b.WriteString("Stack:")
+	for _, addr := range c8.stack[:c8.sp] {
+		fmt.Fprintf(&b, " 0x%03x", addr)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// BugReport returns a description of the ROM, configuration and machine
+// state, along with the most recently executed instructions, for
+// reproducing an issue.
+func (c8 *Chip8) BugReport() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "ROM: %s (%d bytes)\n", c8.romName, len(c8.rom))
+	fmt.Fprintf(&b, "SHA-256: %s\n", c8.RomHash())
+	fmt.Fprintf(&b, "Seed: %d\n", c8.seed)
+	fmt.Fprintf(&b, "Quirks: %+v\n", c8.Quirks)
+	fmt.Fprintf(&b, "Display hash: %016x\n", c8.FrameHash())
+	b.WriteString(c8.DumpState())
+	b.WriteString("Recent instructions:\n")
+	n := c8.traceLen
+	if n > traceSize {
+		n = traceSize
+	}
+	for i := c8.traceLen - n; i < c8.traceLen; i++ {
+		e := c8.trace[i%traceSize]
+		fmt.Fprintf(&b, "  0x%03x  %04x  %s\n", e.pc, e.op, Disassemble(e.op))
+	}
+	op := c8.CurrentOpcode()
+	fmt.Fprintf(&b, "> 0x%03x  %04x  %s\n", c8.pc, op, Disassemble(op))
+	return b.String()
+}
+
+// CurrentOpcode returns the instruction at PC, i.e. the next one to execute.
+func (c8 *Chip8) CurrentOpcode() uint16 {
+	if int(c8.pc)+1 >= len(c8.mem) {
+		return 0
+	}
+	return (uint16(c8.mem[c8.pc]) << 8) | uint16(c8.mem[c8.pc+1])
+}
+
+// FontDisplay returns a display showing the glyphs of the digits 0-F as Fx29
+// and DRW draw them, read from memory rather than the built-in font, in two
+// rows of eight.
+func (c8 *Chip8) FontDisplay() [DisplayWidth][DisplayHeight]uint8 {
+	var gfx [DisplayWidth][DisplayHeight]uint8
+	const cell = DisplayWidth / 8
+	top := (DisplayHeight - 2*cell) / 2
+	for digit := 0; digit < 0x10; digit++ {
+		x0, y0 := digit%8*cell+2, top+digit/8*cell+1
+		for row, b := range c8.mem[digit*5 : digit*5+5] {
+			for col := 0; col < 8; col++ {
+				if b&(0x80>>col) != 0 && x0+col < DisplayWidth {
+					gfx[x0+col][y0+row] = 1
+				}
+			}
+		}
+	}
+	return gfx
+}
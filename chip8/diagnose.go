@@ -0,0 +1,97 @@
+package chip8
+
+// A check a quirks test ROM makes of a quirk. The program leaves register reg
+// holding on with the quirk enabled and off without. After its checks the ROM
+// clears the display and shows each value as a font digit, the first check's
+// at the top left and the others every 8 pixels to the right.
+type quirkCheck struct {
+	quirk   string // Name as accepted by Quirks.Enable
+	program []uint8
+	reg     int
+	on, off uint8
+}
+
+// The checks of testdata/quirks.ch8, in the order it runs them. The ROM runs
+// each program followed by LD Vn, Vreg saving the result in V3 onwards. The
+// jump check uses absolute addresses, it must come first to start at
+// RomLoadAddr.
+var vipQuirkChecks = []quirkCheck{
+	{"jump", []uint8{
+		0x60, 0x00, // LD V0, 0
+		0x62, 0x04, // LD V2, 4
+		0xb2, 0x06, // JP V0, 0x206, or 0x206 + V2 with the quirk
+		0x60, 0x01, // 0x206: LD V0, 1
+		0x12, 0x0c, // JP 0x20C
+		0x60, 0x02, // 0x20A: LD V0, 2
+	}, 0x0, 2, 1},
+	{"clip", []uint8{
+		0xa0, 0x00, // LD I, 0 (the top of the 0 glyph, 4 pixels wide)
+		0x60, 0x3e, // LD V0, 62
+		0x61, 0x00, // LD V1, 0
+		0xd0, 0x11, // DRW V0, V1, 1 (wraps onto x = 0 without the quirk)
+		0x60, 0x00, // LD V0, 0
+		0xd0, 0x11, // DRW V0, V1, 1
+	}, 0xf, 0, 1},
+	{"vfreset", []uint8{
+		0x6f, 0x07, // LD VF, 7
+		0x60, 0x01, // LD V0, 1
+		0x61, 0x02, // LD V1, 2
+		0x80, 0x11, // OR V0, V1
+	}, 0xf, 0, 7},
+	{"shift", []uint8{
+		0x60, 0x01, // LD V0, 1
+		0x61, 0x04, // LD V1, 4
+		0x80, 0x16, // SHR V0, V1
+	}, 0x0, 0, 2},
+	{"loadstore", []uint8{
+		0xa4, 0x00, // LD I, 0x400
+		0x60, 0x05, // LD V0, 5
+		0xf0, 0x55, // LD [I], V0
+		0x60, 0x09, // LD V0, 9
+		0xf0, 0x65, // LD V0, [I]
+	}, 0x0, 0, 5},
+}
+
+// Checks made by quirks test ROMs, keyed by the SHA-256 hash of the ROM as
+// returned by RomHash. The results are compared with those expected with the
+// profile of the ROM in romProfiles. Only the bundled testdata/quirks.ch8 is
+// known for now.
+var quirkTests = map[string][]quirkCheck{
+	quirksRomHash: vipQuirkChecks,
+}
+
+// Reports whether the font digit d is shown with its top left at x, y.
+func (c8 *Chip8) showsDigit(x, y int, d uint8) bool {
+	for row, bits := range fontset[int(d)*5 : int(d)*5+5] {
+		for col := 0; col < 4; col++ {
+			on := bits&(0x80>>col) != 0
+			if (c8.Gfx[x+col][y+row] != 0) != on {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// DiagnoseQuirks reads the results shown by a known quirks test ROM and
+// returns a description of each quirk the test failed for, e.g. "shift quirk
+// appears wrong". It returns nothing for other ROMs, which for now includes
+// every ROM but the bundled testdata/quirks.ch8, or before the test has shown
+// its results.
+func (c8 *Chip8) DiagnoseQuirks() []string {
+	hash := c8.RomHash()
+	profile := Profiles[romProfiles[hash]]
+	want := profile.byName()
+	var found []string
+	for n, check := range quirkTests[hash] {
+		expected, wrong := check.off, check.on
+		if *want[check.quirk] {
+			expected, wrong = wrong, expected
+		}
+		x := 8 * n
+		if !c8.showsDigit(x, 0, expected) && c8.showsDigit(x, 0, wrong) {
+			found = append(found, check.quirk+" quirk appears wrong")
+		}
+	}
+	return found
+}
@@ -0,0 +1,131 @@
+package chip8
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Disassemble returns the assembly of a single instruction, e.g.
+// "DRW V1, V2, 5". Opcodes that aren't instructions are shown as data words.
+func Disassemble(op uint16) string {
+	info, ok := decode(op)
+	if !ok {
+		return fmt.Sprintf("DW 0x%04x", op)
+	}
+	if info.Operands == "" {
+		return info.Mnemonic
+	}
+	r := strings.NewReplacer(
+		"Vx", fmt.Sprintf("V%X", (op&0xf00)>>8),
+		"Vy", fmt.Sprintf("V%X", (op&0xf0)>>4),
+		"byte", fmt.Sprintf("0x%02x", op&0xff),
+		"addr", fmt.Sprintf("0x%03x", op&0xfff),
+		"nibble", fmt.Sprintf("%d", op&0xf),
+		"mask", fmt.Sprintf("%d", (op&0xf00)>>8),
+		// Optional operands are not part of the syntax.
+		" {, Vy}", "",
+	)
+	return info.Mnemonic + " " + r.Replace(info.Operands)
+}
+
+// DisassembleRom returns a listing of a ROM, one line per instruction with
+// its address as loaded.
+func DisassembleRom(rom []byte) []string {
+	var lines []string
+	for i := 0; i < len(rom); i += 2 {
+		addr := int(RomLoadAddr) + i
+		if i+1 == len(rom) {
+			lines = append(lines,
+				fmt.Sprintf("0x%03x  %02x    DB 0x%02x", addr, rom[i], rom[i]))
+			break
+		}
+		op := uint16(rom[i])<<8 | uint16(rom[i+1])
+		lines = append(lines,
+			fmt.Sprintf("0x%03x  %04x  %s", addr, op, Disassemble(op)))
+	}
+	return lines
+}
+
+// LoadAndDisassemble loads a ROM and returns it along with its listing, see
+// DisassembleRom.
+func (c8 *Chip8) LoadAndDisassemble(r io.Reader) ([]byte, []string, error) {
+	rom, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading ROM file: %v", err)
+	}
+	if err := c8.LoadRomData(rom); err != nil {
+		return nil, nil, err
+	}
+	return rom, DisassembleRom(rom), nil
+}
+
+// DisassembleAround returns a listing of the instructions from before
+// instructions before PC to after instructions after it, as if instructions
+// were at even distances from PC. The line of the instruction at PC is marked
+// with ">", as in BugReport.
+func (c8 *Chip8) DisassembleAround(before, after int) []string {
+	start := int(c8.pc) - 2*before
+	for start < 0 {
+		start += 2
+	}
+	var lines []string
+	for addr := start; addr <= int(c8.pc)+2*after; addr += 2 {
+		if addr+1 >= len(c8.mem) {
+			break
+		}
+		op := uint16(c8.mem[addr])<<8 | uint16(c8.mem[addr+1])
+		mark := " "
+		if addr == int(c8.pc) {
+			mark = ">"
+		}
+		lines = append(lines,
+			fmt.Sprintf("%s 0x%03x  %04x  %s", mark, addr, op, Disassemble(op)))
+	}
+	return lines
+}
+
+// CallTargets returns the addresses called by CALL instructions in the loaded
+// ROM, likely subroutine entry points, in order. The ROM is scanned as
+// instructions at even addresses, so data may give spurious targets.
+func (c8 *Chip8) CallTargets() []uint16 {
+	return targets(c8.rom, 0x2000)
+}
+
+// JumpTargets returns the addresses jumped to by JP addr instructions in the
+// loaded ROM, in order, see CallTargets. Targets of JP V0, addr depend on V0
+// and are not included, see DynamicJumps.
+func (c8 *Chip8) JumpTargets() []uint16 {
+	return targets(c8.rom, 0x1000)
+}
+
+// DynamicJumps returns the addresses of the JP V0, addr instructions in the
+// loaded ROM, whose targets are computed at run time.
+func (c8 *Chip8) DynamicJumps() []uint16 {
+	var addrs []uint16
+	for i := 0; i+1 < len(c8.rom); i += 2 {
+		if c8.rom[i]&0xf0 == 0xb0 {
+			addrs = append(addrs, RomLoadAddr+uint16(i))
+		}
+	}
+	return addrs
+}
+
+// Returns the distinct nnn operands of the instructions in rom with the
+// opcode kind in the high nibble, sorted.
+func targets(rom []byte, kind uint16) []uint16 {
+	seen := make(map[uint16]bool)
+	for i := 0; i+1 < len(rom); i += 2 {
+		op := uint16(rom[i])<<8 | uint16(rom[i+1])
+		if op&0xf000 == kind {
+			seen[op&0xfff] = true
+		}
+	}
+	addrs := make([]uint16, 0, len(seen))
+	for addr := range seen {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
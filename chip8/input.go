@@ -0,0 +1,108 @@
+package chip8
+
+import "sort"
+
+type keyEvent struct {
+	frame   uint64
+	key     uint8
+	pressed bool
+}
+
+// Keys pressed and released while Fx0A waits for a key.
+type keyWait struct {
+	active  bool
+	down    [0x10]bool // Pressed since the wait started
+	key     uint8
+	latched bool // Whether key was pressed and released
+}
+
+// SetKey sets the state of a key of the hex keypad. It is safe to call
+// concurrently with emulation.
+func (c8 *Chip8) SetKey(key uint8, pressed bool) {
+	c8.keyMu.Lock()
+	defer c8.keyMu.Unlock()
+	key &= 0xf
+	w := &c8.keyWait
+	if c8.StickyKeys {
+		if !pressed {
+			return
+		}
+		if w.active && !w.latched {
+			// A tap answers Fx0A without latching the key.
+			w.key, w.latched = key, true
+			return
+		}
+		pressed = !c8.Key[key]
+	}
+	c8.Key[key] = pressed
+	if w.active && !w.latched {
+		if pressed {
+			w.down[key] = true
+		} else if w.down[key] {
+			w.key, w.latched = key, true
+		}
+	}
+}
+
+// KeyPressed reports whether a key of the hex keypad is pressed. It is safe to
+// call concurrently with emulation.
+func (c8 *Chip8) KeyPressed(key uint8) bool {
+	c8.keyMu.Lock()
+	defer c8.keyMu.Unlock()
+	return c8.Key[key&0xf]
+}
+
+// Returns the key pressed and released since Fx0A started waiting, which
+// starts the wait if it hasn't.
+func (c8 *Chip8) waitedKey() (uint8, bool) {
+	c8.keyMu.Lock()
+	defer c8.keyMu.Unlock()
+	w := &c8.keyWait
+	if !w.latched {
+		w.active = true
+		return 0, false
+	}
+	key := w.key
+	*w = keyWait{}
+	return key, true
+}
+
+// WaitingForKey reports whether Fx0A is waiting for a key, and the register
+// the key will be stored in. Fx0A waits between steps or cycles when it
+// doesn't block, see Step.
+func (c8 *Chip8) WaitingForKey() (waiting bool, reg uint8) {
+	op := c8.CurrentOpcode()
+	if op&0xf0ff != 0xf00a {
+		return false, 0
+	}
+	c8.keyMu.Lock()
+	defer c8.keyMu.Unlock()
+	return c8.keyWait.active, uint8(op>>8) & 0xf
+}
+
+// QueueKeyEvent schedules a key to be pressed or released at the start of the
+// given frame (see Frame), e.g. to script input for demos and tests. Events
+// for the current or past frames are applied immediately.
+func (c8 *Chip8) QueueKeyEvent(frame uint64, key uint8, pressed bool) {
+	if frame <= c8.frame {
+		c8.SetKey(key, pressed)
+		return
+	}
+	// Keep the queue ordered by frame, events of the same frame in the order
+	// they were queued.
+	i := sort.Search(len(c8.keyQueue), func(i int) bool {
+		return c8.keyQueue[i].frame > frame
+	})
+	c8.keyQueue = append(c8.keyQueue, keyEvent{})
+	copy(c8.keyQueue[i+1:], c8.keyQueue[i:])
+	c8.keyQueue[i] = keyEvent{frame, key, pressed}
+}
+
+// Applies the queued key events that are due by the current frame.
+func (c8 *Chip8) applyKeyEvents() {
+	n := 0
+	for ; n < len(c8.keyQueue) && c8.keyQueue[n].frame <= c8.frame; n++ {
+		c8.SetKey(c8.keyQueue[n].key, c8.keyQueue[n].pressed)
+	}
+	c8.keyQueue = c8.keyQueue[n:]
+}
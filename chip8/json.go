@@ -0,0 +1,45 @@
+package chip8
+
+import (
+	"encoding/hex"
+	"encoding/json"
+)
+
+// Version of the JSON representation of the machine state, incremented on
+// incompatible changes.
+const stateVersion = 1
+
+// The machine state as represented in JSON. Large arrays are hex strings.
+type jsonState struct {
+	Version int          `json:"version"`
+	V       [0x10]uint8  `json:"v"`
+	I       uint16       `json:"i"`
+	PC      uint16       `json:"pc"`
+	SP      uint8        `json:"sp"`
+	Stack   [0x10]uint16 `json:"stack"`
+	DT      uint8        `json:"dt"`
+	ST      uint8        `json:"st"`
+	Memory  string       `json:"memory"`  // Hex
+	Display string       `json:"display"` // Hex, indexed by x then y like Gfx
+}
+
+// MarshalJSON returns the state of the machine as a JSON object, for external
+// tools. Registers are numbers, memory and the display hex strings.
+func (c8 *Chip8) MarshalJSON() ([]byte, error) {
+	display := make([]uint8, 0, DisplayWidth*DisplayHeight)
+	for x := range c8.Gfx {
+		display = append(display, c8.Gfx[x][:]...)
+	}
+	return json.Marshal(jsonState{
+		Version: stateVersion,
+		V:       c8.v,
+		I:       c8.i,
+		PC:      c8.pc,
+		SP:      c8.sp,
+		Stack:   c8.stack,
+		DT:      c8.dt,
+		ST:      c8.st,
+		Memory:  hex.EncodeToString(c8.mem[:]),
+		Display: hex.EncodeToString(display),
+	})
+}
@@ -0,0 +1,93 @@
+package chip8
+
+import "time"
+
+// OpcodeInfo describes an instruction implemented by the interpreter.
+type OpcodeInfo struct {
+	Pattern     string // E.g. "8xy4"
+	Mnemonic    string // E.g. "ADD"
+	Operands    string // E.g. "Vx, Vy"
+	Description string
+	mask, value uint16
+}
+
+// The instructions implemented by Cycle, descriptions as in Cowgod's
+// reference [1]. An opcode op is an instance of an entry if
+// op&mask == value.
+var opcodes = [...]OpcodeInfo{
+	{"00E0", "CLS", "", "Clear the display.", 0xffff, 0x00e0},
+	{"00EE", "RET", "", "Return from a subroutine.", 0xffff, 0x00ee},
+	{"00FD", "EXIT", "", "Exit the interpreter (SCHIP).", 0xffff, 0x00fd},
+	// Must come after 00E0, 00EE and 00FD, which take precedence.
+	{"0nnn", "SYS", "addr", "Jump to a machine code routine at nnn.", 0xf000, 0x0000},
+	{"1nnn", "JP", "addr", "Jump to location nnn.", 0xf000, 0x1000},
+	{"2nnn", "CALL", "addr", "Call subroutine at nnn.", 0xf000, 0x2000},
+	{"3xkk", "SE", "Vx, byte", "Skip next instruction if Vx = kk.", 0xf000, 0x3000},
+	{"4xkk", "SNE", "Vx, byte", "Skip next instruction if Vx != kk.", 0xf000, 0x4000},
+	{"5xy0", "SE", "Vx, Vy", "Skip next instruction if Vx = Vy.", 0xf00f, 0x5000},
+	{"6xkk", "LD", "Vx, byte", "Set Vx = kk.", 0xf000, 0x6000},
+	{"7xkk", "ADD", "Vx, byte", "Set Vx = Vx + kk.", 0xf000, 0x7000},
+	{"8xy0", "LD", "Vx, Vy", "Set Vx = Vy.", 0xf00f, 0x8000},
+	{"8xy1", "OR", "Vx, Vy", "Set Vx = Vx OR Vy.", 0xf00f, 0x8001},
+	{"8xy2", "AND", "Vx, Vy", "Set Vx = Vx AND Vy.", 0xf00f, 0x8002},
+	{"8xy3", "XOR", "Vx, Vy", "Set Vx = Vx XOR Vy.", 0xf00f, 0x8003},
+	{"8xy4", "ADD", "Vx, Vy", "Set Vx = Vx + Vy, set VF = carry.", 0xf00f, 0x8004},
+	{"8xy5", "SUB", "Vx, Vy", "Set Vx = Vx - Vy, set VF = NOT borrow.", 0xf00f, 0x8005},
+	{"8xy6", "SHR", "Vx {, Vy}", "Set Vx = Vx SHR 1.", 0xf00f, 0x8006},
+	{"8xy7", "SUBN", "Vx, Vy", "Set Vx = Vy - Vx, set VF = NOT borrow.", 0xf00f, 0x8007},
+	{"8xyE", "SHL", "Vx {, Vy}", "Set Vx = Vx SHL 1.", 0xf00f, 0x800e},
+	{"9xy0", "SNE", "Vx, Vy", "Skip next instruction if Vx != Vy.", 0xf00f, 0x9000},
+	{"Annn", "LD", "I, addr", "Set I = nnn.", 0xf000, 0xa000},
+	{"Bnnn", "JP", "V0, addr", "Jump to location nnn + V0.", 0xf000, 0xb000},
+	{"Cxkk", "RND", "Vx, byte", "Set Vx = random byte AND kk.", 0xf000, 0xc000},
+	{"Dxyn", "DRW", "Vx, Vy, nibble", "Display n-byte sprite starting at memory location I at (Vx, Vy), set VF = collision.", 0xf000, 0xd000},
+	{"Ex9E", "SKP", "Vx", "Skip next instruction if key with the value of Vx is pressed.", 0xf0ff, 0xe09e},
+	{"ExA1", "SKNP", "Vx", "Skip next instruction if key with the value of Vx is not pressed.", 0xf0ff, 0xe0a1},
+	{"Fn01", "PLANE", "mask", "Select the bitplanes drawn to (XO-CHIP).", 0xf0ff, 0xf001},
+	{"Fx07", "LD", "Vx, DT", "Set Vx = delay timer value.", 0xf0ff, 0xf007},
+	{"Fx0A", "LD", "Vx, K", "Wait for a key press, store the value of the key in Vx.", 0xf0ff, 0xf00a},
+	{"Fx15", "LD", "DT, Vx", "Set delay timer = Vx.", 0xf0ff, 0xf015},
+	{"Fx18", "LD", "ST, Vx", "Set sound timer = Vx.", 0xf0ff, 0xf018},
+	{"Fx1E", "ADD", "I, Vx", "Set I = I + Vx.", 0xf0ff, 0xf01e},
+	{"Fx29", "LD", "F, Vx", "Set I = location of sprite for digit Vx.", 0xf0ff, 0xf029},
+	{"Fx33", "LD", "B, Vx", "Store BCD representation of Vx in memory locations I, I+1, and I+2.", 0xf0ff, 0xf033},
+	{"Fx55", "LD", "[I], Vx", "Store registers V0 through Vx in memory starting at location I.", 0xf0ff, 0xf055},
+	{"Fx65", "LD", "Vx, [I]", "Read registers V0 through Vx from memory starting at location I.", 0xf0ff, 0xf065},
+}
+
+// SupportedOpcodes returns the instructions implemented by the interpreter.
+func SupportedOpcodes() []OpcodeInfo {
+	return append([]OpcodeInfo(nil), opcodes[:]...)
+}
+
+// Approximate execution times of the instructions on the COSMAC VIP, in
+// microseconds, by pattern. DRW includes waiting for the display interrupt.
+var vipTimes = map[string]int{
+	"00E0": 109, "00EE": 105, "00FD": 105, "0nnn": 105, "1nnn": 105, "2nnn": 105,
+	"3xkk": 55, "4xkk": 55, "5xy0": 73, "6xkk": 27, "7xkk": 45,
+	"8xy0": 200, "8xy1": 200, "8xy2": 200, "8xy3": 200, "8xy4": 200,
+	"8xy5": 200, "8xy6": 200, "8xy7": 200, "8xyE": 200, "9xy0": 73,
+	"Annn": 55, "Bnnn": 105, "Cxkk": 164, "Dxyn": 22734, "Ex9E": 73,
+	"ExA1": 73, "Fn01": 45, "Fx07": 45, "Fx0A": 45, "Fx15": 45, "Fx18": 45,
+	"Fx1E": 86, "Fx29": 91, "Fx33": 927, "Fx55": 605, "Fx65": 605,
+}
+
+// VIPTime returns the approximate time the instruction op takes on the
+// COSMAC VIP. Opcodes that aren't instructions take the time of a jump.
+func VIPTime(op uint16) time.Duration {
+	info, ok := decode(op)
+	if !ok {
+		info.Pattern = "1nnn"
+	}
+	return time.Duration(vipTimes[info.Pattern]) * time.Microsecond
+}
+
+// Looks up the instruction op is an instance of.
+func decode(op uint16) (OpcodeInfo, bool) {
+	for _, info := range opcodes {
+		if op&info.mask == info.value {
+			return info, true
+		}
+	}
+	return OpcodeInfo{}, false
+}
@@ -0,0 +1,35 @@
+package chip8
+
+import (
+	"image"
+	"image/color"
+)
+
+// Palette maps the values of a display, the bitplanes a pixel is set in, to
+// colors. The first color is the background. Classic programs need 2 colors,
+// XO-CHIP programs drawing to both planes 4.
+type Palette []color.Color
+
+// Index returns the index of the color of a display value. Values without a
+// color of their own, as drawn by XO-CHIP programs on a 2-color palette, get
+// the last color.
+func (p Palette) Index(v uint8) uint8 {
+	if int(v) >= len(p) {
+		return uint8(len(p) - 1)
+	}
+	return v
+}
+
+// Image returns the display gfx, e.g. Chip8.Gfx, as an image with one pixel
+// per Chip-8 pixel.
+func (p Palette) Image(
+	gfx *[DisplayWidth][DisplayHeight]uint8) *image.Paletted {
+	img := image.NewPaletted(
+		image.Rect(0, 0, DisplayWidth, DisplayHeight), color.Palette(p))
+	for x := range gfx {
+		for y, v := range gfx[x] {
+			img.SetColorIndex(x, y, p.Index(v))
+		}
+	}
+	return img
+}
@@ -0,0 +1,53 @@
+package chip8
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Hotspot is an address of the program along with how many times the
+// instruction there was executed.
+type Hotspot struct {
+	Addr  uint16
+	Count uint64
+}
+
+// EnableProfiling starts counting the executions of each instruction, see
+// Hotspots. Counting has no cost unless enabled.
+func (c8 *Chip8) EnableProfiling() {
+	if c8.pcCounts == nil {
+		c8.pcCounts = make([]uint64, len(c8.mem))
+	}
+}
+
+// Hotspots returns the executed instructions, most executed first.
+func (c8 *Chip8) Hotspots() []Hotspot {
+	var hs []Hotspot
+	for addr, n := range c8.pcCounts {
+		if n > 0 {
+			hs = append(hs, Hotspot{uint16(addr), n})
+		}
+	}
+	sort.SliceStable(hs, func(i, j int) bool { return hs[i].Count > hs[j].Count })
+	return hs
+}
+
+// WriteHotspots writes a listing of the executed instructions, most executed
+// first, with their share of all executed instructions and disassembly.
+func (c8 *Chip8) WriteHotspots(w io.Writer) error {
+	hs := c8.Hotspots()
+	var total uint64
+	for _, h := range hs {
+		total += h.Count
+	}
+	for _, h := range hs {
+		op := uint16(c8.mem[h.Addr])<<8 | uint16(c8.mem[(h.Addr+1)%uint16(len(c8.mem))])
+		_, err := fmt.Fprintf(w, "%12d %6.2f%%  0x%03x  %04x  %s\n",
+			h.Count, 100*float64(h.Count)/float64(total), h.Addr, op, Disassemble(op))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
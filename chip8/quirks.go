@@ -0,0 +1,94 @@
+package chip8
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Quirks selects between behaviors that differ among Chip-8 interpreters,
+// which programs written for one of them may depend on.
+type Quirks struct {
+	VFReset   bool // 8xy1, 8xy2 and 8xy3 reset VF to 0
+	LoadStore bool // Fx55 and Fx65 increment I past the registers accessed
+	Shift     bool // 8xy6 and 8xyE shift Vx in place instead of setting Vx = Vy
+	Jump      bool // Bnnn jumps to nnn + Vx, x being the high nibble of nnn
+	Clip      bool // Sprites are clipped at the display edges instead of wrapping
+	// 8xy4, 8xy5 and 8xy7 write VF before the result instead of after, so
+	// with VF as Vx it holds the result rather than the flag, as in some
+	// interpreters.
+	ResultLast bool
+	// Sprites are drawn at their coordinates as is rather than modulo the
+	// display size, so a sprite starting outside the display is clipped
+	// away instead of wrapping onto it.
+	ClampStartCoord bool
+	// The delay and sound timers stop while Fx0A waits for a key, as on the
+	// COSMAC VIP, instead of counting down.
+	WaitKeyFreezesTimers bool
+}
+
+// Returns the fields of q by the names accepted by Enable.
+func (q *Quirks) byName() map[string]*bool {
+	return map[string]*bool{
+		"vfreset":              &q.VFReset,
+		"loadstore":            &q.LoadStore,
+		"shift":                &q.Shift,
+		"jump":                 &q.Jump,
+		"clip":                 &q.Clip,
+		"resultlast":           &q.ResultLast,
+		"clampstartcoord":      &q.ClampStartCoord,
+		"waitkeyfreezestimers": &q.WaitKeyFreezesTimers,
+	}
+}
+
+// Enable enables the quirks in a comma separated list of names, the field
+// names in lower case, e.g. "shift,loadstore". Nothing is enabled if a name is
+// unknown.
+func (q *Quirks) Enable(list string) error {
+	fields := q.byName()
+	names := strings.Split(list, ",")
+	for _, name := range names {
+		if _, ok := fields[name]; !ok {
+			var valid []string
+			for name := range fields {
+				valid = append(valid, name)
+			}
+			sort.Strings(valid)
+			return fmt.Errorf("Unknown quirk %q, valid quirks are: %s",
+				name, strings.Join(valid, ", "))
+		}
+	}
+	for _, name := range names {
+		*fields[name] = true
+	}
+	return nil
+}
+
+// DefaultProfile names the quirks of this interpreter when not configured
+// otherwise.
+const DefaultProfile = "default"
+
+// Profiles maps names of interpreters to their quirks.
+var Profiles = map[string]Quirks{
+	DefaultProfile: {Shift: true},
+	"vip":          {VFReset: true, LoadStore: true, Clip: true},
+	"schip":        {Shift: true, Jump: true, Clip: true},
+}
+
+// SHA-256 hash of testdata/quirks.ch8, a test of the quirks of the COSMAC VIP.
+const quirksRomHash = "215785fb0c1d5b3f92afb76e52733a11d13d3e81f9ec34de2d1b9d6d5b3409ed"
+
+// Profiles known to be required by specific ROMs, keyed by the SHA-256 hash
+// of the ROM as returned by RomHash. Entries should be taken from community
+// compatibility lists or verified by running the ROM.
+var romProfiles = map[string]string{
+	quirksRomHash: "vip",
+}
+
+// LookupProfile returns the name of the profile a ROM with the given hash is
+// known to need. The database only covers the bundled testdata/quirks.ch8 for
+// now, other ROMs are not found.
+func LookupProfile(romHash string) (string, bool) {
+	name, ok := romProfiles[romHash]
+	return name, ok
+}
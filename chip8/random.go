@@ -0,0 +1,75 @@
+package chip8
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// First line of a random log, followed by the hash of the ROM.
+const randomLogHeader = "chip8-random"
+
+// Returns the next random byte for Cxkk, replayed or from the generator, and
+// records it if recording.
+func (c8 *Chip8) randomByte() uint8 {
+	var b uint8
+	if c8.replayPos < len(c8.replay) {
+		b = c8.replay[c8.replayPos]
+		c8.replayPos++
+	} else {
+		if c8.replay != nil && c8.replayPos == len(c8.replay) {
+			c8.warn("Random log exhausted, using the generator")
+			c8.replayPos++
+		}
+		b = uint8(c8.rng.Intn(0x100))
+	}
+	if c8.recordRandom {
+		c8.randomLog = append(c8.randomLog, b)
+	}
+	return b
+}
+
+// RecordRandom starts recording the random bytes used by Cxkk, see
+// WriteRandomLog. The recording restarts on reset.
+func (c8 *Chip8) RecordRandom() {
+	c8.recordRandom = true
+}
+
+// WriteRandomLog writes the random bytes recorded since reset, along with the
+// hash of the ROM, for ReadRandomLog.
+func (c8 *Chip8) WriteRandomLog(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%s %s\n", randomLogHeader, c8.RomHash())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(c8.randomLog)
+	return err
+}
+
+// ReadRandomLog reads random bytes written by WriteRandomLog and returns them
+// along with the hash of the ROM they were recorded with.
+func ReadRandomLog(r io.Reader) (romHash string, data []byte, err error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return "", nil, errors.New("Invalid random log: no header")
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 2 || fields[0] != randomLogHeader {
+		return "", nil, errors.New("Invalid random log header")
+	}
+	data, err = io.ReadAll(br)
+	if err != nil {
+		return "", nil, fmt.Errorf("Error reading random log: %v", err)
+	}
+	return fields[1], data, nil
+}
+
+// ReplayRandom makes Cxkk use the given bytes, in order, instead of the
+// generator, until they run out. The replay restarts on reset.
+func (c8 *Chip8) ReplayRandom(data []byte) {
+	c8.replay = append([]byte{}, data...)
+	c8.replayPos = 0
+}
@@ -0,0 +1,374 @@
+package chip8
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// A straightforward interpreter to check Cycle against, written for clarity
+// rather than speed: state is copied by value and every instruction is decoded
+// from its nibbles. Keys are never pressed.
+type refMachine struct {
+	v      [16]uint8
+	i, pc  uint16
+	sp     uint8
+	stack  [16]uint16
+	dt, st uint8
+	mem    []uint8
+	gfx    [DisplayWidth][DisplayHeight]uint8
+	planes uint8
+	quirks Quirks
+	rng    *rand.Rand
+}
+
+// The reference does not define the result, e.g. on stack overflow or memory
+// accessed past its end. Such programs are cut short.
+var errUndefined = errors.New("undefined behavior")
+
+func newRefMachine(c8 *Chip8, seed int64) *refMachine {
+	return &refMachine{
+		pc:     c8.pc,
+		mem:    append([]uint8(nil), c8.mem[:]...),
+		planes: 1,
+		quirks: c8.Quirks,
+		rng:    rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (m *refMachine) step() error {
+	if int(m.pc)+1 >= len(m.mem) {
+		return fmt.Errorf("PC out of bounds")
+	}
+	op := uint16(m.mem[m.pc])<<8 | uint16(m.mem[m.pc+1])
+	kind := op >> 12
+	x := int(op >> 8 & 0xf)
+	y := int(op >> 4 & 0xf)
+	n := int(op & 0xf)
+	kk := uint8(op)
+	nnn := op & 0xfff
+	next := m.pc + 2
+	skip := m.pc + 4
+	switch {
+	case op == 0x00e0:
+		for col := range m.gfx {
+			for row := range m.gfx[col] {
+				m.gfx[col][row] &^= m.planes
+			}
+		}
+		m.pc = next
+	case op == 0x00ee:
+		if m.sp == 0 {
+			return errUndefined
+		}
+		m.sp--
+		m.pc = m.stack[m.sp] + 2
+	case op == 0x00fd:
+		// The machine halts, which ends the comparison.
+		return errUndefined
+	case kind == 0x0:
+		m.pc = next
+	case kind == 0x1:
+		m.pc = nnn
+	case kind == 0x2:
+		if int(m.sp) == len(m.stack) {
+			return errUndefined
+		}
+		m.stack[m.sp] = m.pc
+		m.sp++
+		m.pc = nnn
+	case kind == 0x3:
+		m.pc = next
+		if m.v[x] == kk {
+			m.pc = skip
+		}
+	case kind == 0x4:
+		m.pc = next
+		if m.v[x] != kk {
+			m.pc = skip
+		}
+	case kind == 0x5 && n == 0:
+		m.pc = next
+		if m.v[x] == m.v[y] {
+			m.pc = skip
+		}
+	case kind == 0x6:
+		m.v[x] = kk
+		m.pc = next
+	case kind == 0x7:
+		m.v[x] = uint8(int(m.v[x]) + int(kk))
+		m.pc = next
+	case kind == 0x8 && n <= 0x3:
+		switch n {
+		case 0x0:
+			m.v[x] = m.v[y]
+		case 0x1:
+			m.v[x] = m.v[x] | m.v[y]
+		case 0x2:
+			m.v[x] = m.v[x] & m.v[y]
+		case 0x3:
+			m.v[x] = m.v[x] ^ m.v[y]
+		}
+		if n != 0x0 && m.quirks.VFReset {
+			m.v[0xf] = 0
+		}
+		m.pc = next
+	case kind == 0x8:
+		a, b := int(m.v[x]), int(m.v[y])
+		var result, flag int
+		switch n {
+		case 0x4:
+			result = a + b
+			if result > 255 {
+				flag = 1
+			}
+		case 0x5:
+			result = a - b
+			if a >= b {
+				flag = 1
+			}
+		case 0x7:
+			result = b - a
+			if b >= a {
+				flag = 1
+			}
+		case 0x6, 0xe:
+			if !m.quirks.Shift {
+				a = b
+			}
+			if n == 0x6 {
+				result, flag = a/2, a%2
+			} else {
+				result, flag = a*2, a/128
+			}
+		default:
+			return errUnknown(op)
+		}
+		// If VF is Vx, the flag wins unless the result is written last.
+		if m.quirks.ResultLast && n != 0x6 && n != 0xe {
+			m.v[0xf] = uint8(flag)
+			m.v[x] = uint8((result + 256) % 256)
+		} else {
+			m.v[x] = uint8((result + 256) % 256)
+			m.v[0xf] = uint8(flag)
+		}
+		m.pc = next
+	case kind == 0x9 && n == 0:
+		m.pc = next
+		if m.v[x] != m.v[y] {
+			m.pc = skip
+		}
+	case kind == 0xa:
+		m.i = nnn
+		m.pc = next
+	case kind == 0xb:
+		if m.quirks.Jump {
+			m.pc = nnn + uint16(m.v[x])
+		} else {
+			m.pc = nnn + uint16(m.v[0])
+		}
+	case kind == 0xc:
+		m.v[x] = kk & uint8(m.rng.Intn(256))
+		m.pc = next
+	case kind == 0xd:
+		if n > 0 {
+			m.draw(m.v[x], m.v[y], n)
+		}
+		m.pc = next
+	case kind == 0xe && kk == 0x9e:
+		m.pc = next
+	case kind == 0xe && kk == 0xa1:
+		m.pc = skip
+	case kind == 0xf && kk == 0x01:
+		m.planes = uint8(x) & 3
+		m.pc = next
+	case kind == 0xf && kk == 0x07:
+		m.v[x] = m.dt
+		m.pc = next
+	case kind == 0xf && kk == 0x0a:
+		// No key is ever pressed, the instruction is retried.
+	case kind == 0xf && kk == 0x15:
+		m.dt = m.v[x]
+		m.pc = next
+	case kind == 0xf && kk == 0x18:
+		m.st = m.v[x]
+		m.pc = next
+	case kind == 0xf && kk == 0x1e:
+		m.i += uint16(m.v[x])
+		m.pc = next
+	case kind == 0xf && kk == 0x29:
+		if m.v[x] > 0xf {
+			return fmt.Errorf("Font digit out of range")
+		}
+		m.i = uint16(m.v[x]) * 5
+		m.pc = next
+	case kind == 0xf && kk == 0x33:
+		if int(m.i)+3 > len(m.mem) {
+			return errUndefined
+		}
+		m.mem[m.i] = m.v[x] / 100
+		m.mem[m.i+1] = m.v[x] / 10 % 10
+		m.mem[m.i+2] = m.v[x] % 10
+		m.pc = next
+	case kind == 0xf && (kk == 0x55 || kk == 0x65):
+		if int(m.i)+x+1 > len(m.mem) {
+			return errUndefined
+		}
+		for r := 0; r <= x; r++ {
+			if kk == 0x55 {
+				m.mem[int(m.i)+r] = m.v[r]
+			} else {
+				m.v[r] = m.mem[int(m.i)+r]
+			}
+		}
+		if m.quirks.LoadStore {
+			m.i += uint16(x) + 1
+		}
+		m.pc = next
+	default:
+		return errUnknown(op)
+	}
+	return nil
+}
+
+// Draws the n rows of sprite data at I for each selected plane, one plane
+// after the other, and sets VF if a lit pixel of a selected plane is cleared.
+func (m *refMachine) draw(x, y uint8, n int) {
+	addr := int(m.i)
+	collision := uint8(0)
+	if m.quirks.ClampStartCoord &&
+		(int(x) >= DisplayWidth || int(y) >= DisplayHeight) {
+		m.v[0xf] = 0
+		return
+	}
+	for _, plane := range []uint8{1, 2} {
+		if m.planes&plane == 0 {
+			continue
+		}
+		for row := 0; row < n; row++ {
+			bits := m.mem[addr%len(m.mem)]
+			addr++
+			for col := 0; col < 8; col++ {
+				if bits&(0x80>>col) == 0 {
+					continue
+				}
+				px := int(x)%DisplayWidth + col
+				py := int(y)%DisplayHeight + row
+				if px >= DisplayWidth || py >= DisplayHeight {
+					if m.quirks.Clip {
+						continue
+					}
+					px, py = px%DisplayWidth, py%DisplayHeight
+				}
+				if m.gfx[px][py]&plane != 0 {
+					collision = 1
+				}
+				m.gfx[px][py] ^= plane
+			}
+		}
+	}
+	m.v[0xf] = collision
+}
+
+// Returns a description of the first difference between the machines.
+func (m *refMachine) diff(c8 *Chip8) string {
+	switch {
+	case c8.v != m.v:
+		return fmt.Sprintf("V = %x, want %x", c8.v, m.v)
+	case c8.i != m.i:
+		return fmt.Sprintf("I = 0x%03x, want 0x%03x", c8.i, m.i)
+	case c8.pc != m.pc:
+		return fmt.Sprintf("PC = 0x%03x, want 0x%03x", c8.pc, m.pc)
+	case c8.sp != m.sp || c8.stack != m.stack:
+		return fmt.Sprintf("stack = %x (SP=%d), want %x (SP=%d)",
+			c8.stack, c8.sp, m.stack, m.sp)
+	case c8.dt != m.dt || c8.st != m.st:
+		return fmt.Sprintf("DT, ST = %d, %d, want %d, %d",
+			c8.dt, c8.st, m.dt, m.st)
+	case c8.planes != m.planes:
+		return fmt.Sprintf("planes = %d, want %d", c8.planes, m.planes)
+	case c8.Gfx != m.gfx:
+		return "display differs"
+	}
+	for addr := range m.mem {
+		if c8.mem[addr] != m.mem[addr] {
+			return fmt.Sprintf("memory at 0x%03x = 0x%02x, want 0x%02x",
+				addr, c8.mem[addr], m.mem[addr])
+		}
+	}
+	return ""
+}
+
+// Returns a program of n random instructions. Jumps and calls stay within
+// the program, so that more of it is executed.
+func randomProgram(r *rand.Rand, n int) []uint8 {
+	end := int(RomLoadAddr) + 2*n
+	prog := make([]uint8, 0, 2*n)
+	for len(prog) < 2*n {
+		info := opcodes[r.Intn(len(opcodes))]
+		op := info.value | uint16(r.Intn(0x10000))&^info.mask
+		switch info.Pattern {
+		case "1nnn", "2nnn":
+			op = info.value | uint16(int(RomLoadAddr)+2*r.Intn(n))
+		case "Bnnn":
+			op = info.value | uint16(int(RomLoadAddr)+2*r.Intn(n/2))
+		case "Annn":
+			// Mostly within the program and font, which hold interesting
+			// sprites.
+			if r.Intn(4) > 0 {
+				op = info.value | uint16(r.Intn(end))
+			}
+		case "Fx29":
+			if r.Intn(4) > 0 {
+				op &^= 0x0f00
+			}
+		}
+		prog = append(prog, uint8(op>>8), uint8(op))
+	}
+	return prog
+}
+
+func TestReference(t *testing.T) {
+	const (
+		programs = 500
+		size     = 64
+		steps    = 500
+	)
+	var names []string
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	r := rand.New(rand.NewSource(1))
+	for _, name := range names {
+		for p := 0; p < programs; p++ {
+			prog := randomProgram(r, size)
+			c8 := newTestChip8(t, prog...)
+			c8.Quirks = Profiles[name]
+			ref := newRefMachine(c8, 1)
+			for s := 0; s < steps; s++ {
+				op := c8.CurrentOpcode()
+				pc := c8.pc
+				refErr := ref.step()
+				if refErr == errUndefined {
+					break
+				}
+				err := c8.Step()
+				if (err != nil) != (refErr != nil) {
+					t.Fatalf("%s, program %d, step %d, 0x%03x %04x %s: "+
+						"error %v, want %v", name, p, s, pc, op, Disassemble(op),
+						err, refErr)
+				}
+				if err != nil {
+					break
+				}
+				if d := ref.diff(c8); d != "" {
+					t.Fatalf("%s, program %d, step %d, 0x%03x %04x %s: %s",
+						name, p, s, pc, op, Disassemble(op), d)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package chip8
+
+import "time"
+
+// FrameRate is the rate in hz at which the delay and sound timers count down.
+const FrameRate = 60
+
+// DefaultSpeed is a typical speed, in instructions per second, for running
+// Chip-8 programs.
+const DefaultSpeed = 600
+
+// Programs are rarely playable at speeds outside this range.
+const (
+	MinTypicalSpeed = 100
+	MaxTypicalSpeed = 5000
+)
+
+const frameDuration = time.Second / FrameRate
+
+// Runner paces emulation with a fixed timestep. Every 1/60 s of elapsed time
+// is emulated as one frame: CyclesPerFrame instructions followed by a timer
+// tick. Elapsed time is taken from the monotonic clock so that adjustments of
+// the wall clock don't make emulation drift or jump.
+type Runner struct {
+	C8             *Chip8
+	CyclesPerFrame int
+	// MaxCatchUp is the maximum number of frames emulated by one call to
+	// Advance. Time beyond that (a long GC pause, a breakpoint, a suspended
+	// machine) is dropped rather than caught up in a burst. Zero means no
+	// limit.
+	MaxCatchUp int
+	// Display is the image presented by the last frame, the display as it
+	// was at the end of the frame unless Tearing is set.
+	Display [DisplayWidth][DisplayHeight]uint8
+	// Tearing emulates the COSMAC VIP scanning out the display while the
+	// instructions of a frame run. Each row of Display is taken from the
+	// display as it is when the beam passes the row, so a sprite drawn
+	// mid-frame may be split between two frames.
+	Tearing bool
+	// Timing selects how many instructions a frame runs.
+	Timing Timing
+	// AutoTune, if set, lowers CyclesPerFrame while the host can't run the
+	// frames in time.
+	AutoTune *AutoTune
+	last     time.Time
+	acc      time.Duration
+	budget   time.Duration // Time left in the frame, with TimingAccurate
+	blocked  time.Duration // Time the frame spent waiting for input
+
+	cycles   int // Instructions executed since measured
+	measured time.Time
+	executed int // Instructions executed in total
+}
+
+// Timing selects how a Runner decides how many instructions to run per frame.
+type Timing uint8
+
+const (
+	// Every instruction takes the same time, CyclesPerFrame run per frame.
+	TimingFlat Timing = iota
+	// Instructions take the time they take on the COSMAC VIP, see VIPTime,
+	// and run until their total reaches the duration of a frame.
+	// CyclesPerFrame is ignored. Time taken past the end of a frame is taken
+	// from the next.
+	TimingAccurate
+)
+
+// NewRunner returns a runner emulating speed instructions per second. The
+// runner takes over ticking the timers of c8, so c8.Cycle should not be used
+// alongside it.
+func NewRunner(c8 *Chip8, speed int) *Runner {
+	c8.timer.Stop()
+	cyclesPerFrame := speed / FrameRate
+	if cyclesPerFrame < 1 {
+		cyclesPerFrame = 1
+	}
+	return &Runner{
+		C8:             c8,
+		CyclesPerFrame: cyclesPerFrame,
+		MaxCatchUp:     4,
+		last:           time.Now(),
+		measured:       time.Now(),
+	}
+}
+
+// Advance emulates the frames that are due since the previous call and
+// returns how many were run.
+func (r *Runner) Advance(waitForInput func()) (int, error) {
+	delta := time.Since(r.last)
+	r.last = r.last.Add(delta)
+	return r.advance(delta, waitForInput)
+}
+
+func (r *Runner) advance(delta time.Duration, waitForInput func()) (int, error) {
+	r.acc += delta
+	max := time.Duration(r.MaxCatchUp) * frameDuration
+	if r.MaxCatchUp > 0 && r.acc > max {
+		r.acc = max
+	}
+	frames := 0
+	for ; r.acc >= frameDuration; frames++ {
+		r.acc -= frameDuration
+		if err := r.RunFrame(waitForInput); err != nil {
+			return frames, err
+		}
+	}
+	return frames, nil
+}
+
+// RunFor emulates d worth of time as fast as possible, using a simulated clock
+// instead of the real one, and returns the number of frames run. Fx0A does
+// not wait for input. Useful for running headless and deterministically.
+func (r *Runner) RunFor(d time.Duration) (int, error) {
+	frames := 0
+	for d > 0 {
+		step := frameDuration
+		if d < step {
+			step = d
+		}
+		d -= step
+		n, err := r.advance(step, nil)
+		frames += n
+		if err != nil {
+			return frames, err
+		}
+	}
+	return frames, nil
+}
+
+// MeasuredSpeed returns the number of instructions per second actually
+// emulated since the previous call.
+func (r *Runner) MeasuredSpeed() float64 {
+	now := time.Now()
+	ips := float64(r.cycles) / now.Sub(r.measured).Seconds()
+	r.cycles, r.measured = 0, now
+	return ips
+}
+
+// Cycles returns the number of instructions executed by the runner. Unlike
+// frames times CyclesPerFrame, it counts the instructions actually run with
+// TimingAccurate or IdleDetection.
+func (r *Runner) Cycles() int {
+	return r.executed
+}
+
+// Idle returns the time left until the next frame is due.
+func (r *Runner) Idle() time.Duration {
+	return frameDuration - r.acc - time.Since(r.last)
+}
+
+// RunFrame emulates a single frame and presents it in Display. Draw is set if
+// Display changed.
+func (r *Runner) RunFrame(waitForInput func()) error {
+	c8 := r.C8
+	if c8.stopped {
+		return nil
+	}
+	beam, changed := 0, false
+	defer func() { c8.Draw = changed }()
+	if r.AutoTune != nil {
+		start := time.Now()
+		r.blocked = 0
+		defer func() {
+			// Time spent waiting for a key says nothing about the host.
+			if waiting, _ := c8.WaitingForKey(); !waiting {
+				work := time.Since(start) - r.blocked
+				r.CyclesPerFrame = r.AutoTune.Update(work)
+			}
+		}()
+	}
+	if r.Timing == TimingAccurate {
+		r.budget += frameDuration
+	}
+	wait := waitForInput
+	if wait != nil {
+		wait = func() {
+			start := time.Now()
+			waitForInput()
+			r.tickBlocked()
+			r.blocked += time.Since(start)
+		}
+	}
+	for i := 0; r.inFrame(i); i++ {
+		if r.Tearing {
+			row := r.beamRow(i)
+			changed = r.scanout(beam, row) || changed
+			beam = row
+		}
+		op := c8.CurrentOpcode()
+		if err := c8.exec(wait); err != nil {
+			return err
+		}
+		r.cycles++
+		r.executed++
+		if r.Timing == TimingAccurate {
+			r.budget -= VIPTime(op)
+		}
+		if c8.IdleDetection && c8.WaitingForTimer() {
+			r.budget = 0
+			break
+		}
+	}
+	changed = r.scanout(beam, DisplayHeight) || changed
+	c8.tick()
+	return nil
+}
+
+// Ticks the timers for the frames that passed while Fx0A blocked, so that they
+// keep counting down unless WaitKeyFreezesTimers. The frames are taken from
+// the runner's clock rather than caught up afterwards.
+func (r *Runner) tickBlocked() {
+	delta := time.Since(r.last)
+	r.last = r.last.Add(delta)
+	for r.acc += delta; r.acc >= frameDuration; r.acc -= frameDuration {
+		r.C8.tick()
+	}
+}
+
+// AutoTune adjusts the instructions per frame to the most the host can run in
+// time, up to Target, from the real time frames take to run. It steps by a
+// tenth at a time, and leaves a margin between the times at which it lowers
+// and raises the count, so that it settles instead of oscillating.
+type AutoTune struct {
+	Target int // Instructions per frame wanted
+	cycles int
+	stable int // Updates since the count last changed
+}
+
+// Frame times at which the count is lowered and raised.
+const (
+	tuneSlow = frameDuration * 8 / 10
+	tuneFast = frameDuration / 2
+)
+
+// Number of updates without change after which the count is settled, a
+// second's worth.
+const tuneSettle = FrameRate
+
+// Update takes the time the last frame took to run and returns the number of
+// instructions to run per frame.
+func (a *AutoTune) Update(work time.Duration) int {
+	if a.cycles == 0 {
+		a.cycles = a.Target
+	}
+	step := a.cycles / 10
+	if step < 1 {
+		step = 1
+	}
+	prev := a.cycles
+	switch {
+	case work > tuneSlow && a.cycles > 1:
+		a.cycles -= step
+		if a.cycles < 1 {
+			a.cycles = 1
+		}
+	case work < tuneFast && a.cycles < a.Target:
+		a.cycles += step
+		if a.cycles > a.Target {
+			a.cycles = a.Target
+		}
+	}
+	if a.cycles == prev {
+		a.stable++
+	} else {
+		a.stable = 0
+	}
+	return a.cycles
+}
+
+// Settled reports whether the count has been stable for a while, and the
+// count.
+func (a *AutoTune) Settled() (int, bool) {
+	return a.cycles, a.stable >= tuneSettle
+}
+
+// Reports whether the frame runs the instruction after the first i.
+func (r *Runner) inFrame(i int) bool {
+	if r.Timing == TimingAccurate {
+		return r.budget > 0
+	}
+	return i < r.CyclesPerFrame
+}
+
+// Returns the row of the display the beam is at after the first i
+// instructions of the frame.
+func (r *Runner) beamRow(i int) int {
+	if r.Timing == TimingAccurate {
+		row := int((frameDuration - r.budget) * DisplayHeight / frameDuration)
+		if row < 0 {
+			return 0
+		}
+		return row
+	}
+	return i * DisplayHeight / r.CyclesPerFrame
+}
+
+// WaitingForTimer reports whether the program is in a loop that only waits for
+// the delay timer to change, which it does at the next frame:
+//
+//	loop: LD Vx, DT
+//	      SE Vx, kk (or SNE Vx, kk)
+//	      JP loop
+//
+// PC must be at the start of the loop.
+func (c8 *Chip8) WaitingForTimer() bool {
+	pc := int(c8.pc)
+	if pc+6 > len(c8.mem) {
+		return false
+	}
+	read := func(addr int) uint16 {
+		return uint16(c8.mem[addr])<<8 | uint16(c8.mem[addr+1])
+	}
+	load, skip, jump := read(pc), read(pc+2), read(pc+4)
+	x := load & 0xf00
+	if load&0xf0ff != 0xf007 || skip&0xf00 != x || jump != 0x1000|c8.pc {
+		return false
+	}
+	kk := uint8(skip)
+	switch skip & 0xf000 {
+	case 0x3000:
+		return c8.dt != kk
+	case 0x4000:
+		return c8.dt == kk
+	}
+	return false
+}
+
+// Copies rows [from, to) of the display to Display, reporting whether any
+// pixel changed.
+func (r *Runner) scanout(from, to int) bool {
+	changed := false
+	for x := range r.Display {
+		for y := from; y < to; y++ {
+			if r.Display[x][y] != r.C8.Gfx[x][y] {
+				r.Display[x][y] = r.C8.Gfx[x][y]
+				changed = true
+			}
+		}
+	}
+	return changed
+}
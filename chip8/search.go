@@ -0,0 +1,41 @@
+package chip8
+
+// Memory search, for finding where a program keeps a value such as a score.
+// A search starts with SearchMem and is narrowed down between frames with
+// SearchChanged and SearchUnchanged.
+
+// SearchMem starts a new search and returns the addresses holding value.
+func (c8 *Chip8) SearchMem(value uint8) []uint16 {
+	c8.searchHits = c8.searchHits[:0]
+	for addr, x := range c8.mem {
+		if x == value {
+			c8.searchHits = append(c8.searchHits, uint16(addr))
+		}
+	}
+	c8.searchMem = c8.mem
+	return append([]uint16(nil), c8.searchHits...)
+}
+
+// SearchChanged narrows the search down to the addresses whose value changed
+// since the last search.
+func (c8 *Chip8) SearchChanged() []uint16 {
+	return c8.refineSearch(func(old, new uint8) bool { return old != new })
+}
+
+// SearchUnchanged narrows the search down to the addresses whose value is
+// the same as at the last search.
+func (c8 *Chip8) SearchUnchanged() []uint16 {
+	return c8.refineSearch(func(old, new uint8) bool { return old == new })
+}
+
+func (c8 *Chip8) refineSearch(keep func(old, new uint8) bool) []uint16 {
+	hits := c8.searchHits[:0]
+	for _, addr := range c8.searchHits {
+		if keep(c8.searchMem[addr], c8.mem[addr]) {
+			hits = append(hits, addr)
+		}
+	}
+	c8.searchHits = hits
+	c8.searchMem = c8.mem
+	return append([]uint16(nil), hits...)
+}
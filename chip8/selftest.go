@@ -0,0 +1,140 @@
+package chip8
+
+import "fmt"
+
+// SelfTestResult is the outcome of one of the tests run by SelfTest.
+type SelfTestResult struct {
+	Name string
+	Err  error // Nil if the test passed
+}
+
+// A tiny program exercising an instruction, run for a number of steps before
+// checking the machine state. Before each step, input may set keys.
+type selfTest struct {
+	name  string
+	rom   []uint8
+	steps int
+	input func(c8 *Chip8, step int)
+	check func(c8 *Chip8) bool
+}
+
+var selfTests = [...]selfTest{
+	{"LD Vx, byte", []uint8{0x6a, 0x42}, 1, nil,
+		func(c8 *Chip8) bool { return c8.v[0xa] == 0x42 }},
+	{"ADD Vx, byte", []uint8{0x60, 0xff, 0x70, 0x02}, 2, nil,
+		func(c8 *Chip8) bool { return c8.v[0] == 1 && c8.v[0xf] == 0 }},
+	{"LD Vx, Vy", []uint8{0x61, 0x07, 0x80, 0x10}, 2, nil,
+		func(c8 *Chip8) bool { return c8.v[0] == 7 }},
+	{"OR Vx, Vy", []uint8{0x60, 0x0c, 0x61, 0x0a, 0x80, 0x11}, 3, nil,
+		func(c8 *Chip8) bool { return c8.v[0] == 0x0e }},
+	{"AND Vx, Vy", []uint8{0x60, 0x0c, 0x61, 0x0a, 0x80, 0x12}, 3, nil,
+		func(c8 *Chip8) bool { return c8.v[0] == 0x08 }},
+	{"XOR Vx, Vy", []uint8{0x60, 0x0c, 0x61, 0x0a, 0x80, 0x13}, 3, nil,
+		func(c8 *Chip8) bool { return c8.v[0] == 0x06 }},
+	{"ADD Vx, Vy", []uint8{0x60, 0xff, 0x61, 0x02, 0x80, 0x14}, 3, nil,
+		func(c8 *Chip8) bool { return c8.v[0] == 1 && c8.v[0xf] == 1 }},
+	{"SUB Vx, Vy", []uint8{0x60, 0x05, 0x61, 0x03, 0x80, 0x15}, 3, nil,
+		func(c8 *Chip8) bool { return c8.v[0] == 2 && c8.v[0xf] == 1 }},
+	{"SUBN Vx, Vy", []uint8{0x60, 0x03, 0x61, 0x05, 0x80, 0x17}, 3, nil,
+		func(c8 *Chip8) bool { return c8.v[0] == 2 && c8.v[0xf] == 1 }},
+	{"ADD VF, Vy", []uint8{0x6f, 0xff, 0x61, 0x02, 0x8f, 0x14}, 3, nil,
+		func(c8 *Chip8) bool { return c8.v[0xf] == 1 }},
+	{"ADD Vx, VF", []uint8{0x60, 0xff, 0x6f, 0x02, 0x80, 0xf4}, 3, nil,
+		func(c8 *Chip8) bool { return c8.v[0] == 1 && c8.v[0xf] == 1 }},
+	{"ADD VF, VF", []uint8{0x6f, 0x80, 0x8f, 0xf4}, 2, nil,
+		func(c8 *Chip8) bool { return c8.v[0xf] == 1 }},
+	{"SUB VF, Vy", []uint8{0x6f, 0x01, 0x61, 0x02, 0x8f, 0x15}, 3, nil,
+		func(c8 *Chip8) bool { return c8.v[0xf] == 0 }},
+	{"SHR Vx", []uint8{0x60, 0x05, 0x80, 0x06}, 2, nil,
+		func(c8 *Chip8) bool { return c8.v[0] == 2 && c8.v[0xf] == 1 }},
+	{"SHL Vx", []uint8{0x60, 0x81, 0x80, 0x0e}, 2, nil,
+		func(c8 *Chip8) bool { return c8.v[0] == 2 && c8.v[0xf] == 1 }},
+	{"SE Vx, byte", []uint8{0x30, 0x00, 0x61, 0x01, 0x62, 0x01}, 2, nil,
+		func(c8 *Chip8) bool { return c8.v[1] == 0 && c8.v[2] == 1 }},
+	{"SNE Vx, byte", []uint8{0x40, 0x01, 0x61, 0x01, 0x62, 0x01}, 2, nil,
+		func(c8 *Chip8) bool { return c8.v[1] == 0 && c8.v[2] == 1 }},
+	{"SE Vx, Vy", []uint8{0x50, 0x10, 0x61, 0x01, 0x62, 0x01}, 2, nil,
+		func(c8 *Chip8) bool { return c8.v[1] == 0 && c8.v[2] == 1 }},
+	{"SNE Vx, Vy", []uint8{0x61, 0x01, 0x90, 0x10, 0x62, 0x01, 0x63, 0x01}, 3, nil,
+		func(c8 *Chip8) bool { return c8.v[2] == 0 && c8.v[3] == 1 }},
+	{"JP addr", []uint8{0x12, 0x04, 0x60, 0x01, 0x61, 0x01}, 2, nil,
+		func(c8 *Chip8) bool { return c8.v[0] == 0 && c8.v[1] == 1 }},
+	{"CALL addr, RET", []uint8{
+		0x22, 0x06, // CALL 0x206
+		0x61, 0x01, // LD V1, 0x01
+		0x12, 0x04, // JP 0x204
+		0x60, 0x01, // LD V0, 0x01
+		0x00, 0xee, // RET
+	}, 4, nil,
+		func(c8 *Chip8) bool {
+			return c8.v[0] == 1 && c8.v[1] == 1 && c8.sp == 0 && c8.pc == 0x204
+		}},
+	{"LD I, addr", []uint8{0xa1, 0x23}, 1, nil,
+		func(c8 *Chip8) bool { return c8.i == 0x123 }},
+	{"JP V0, addr", []uint8{0x60, 0x02, 0xb2, 0x04, 0x61, 0x02, 0x61, 0x01}, 3, nil,
+		func(c8 *Chip8) bool { return c8.v[1] == 1 }},
+	{"RND Vx, byte", []uint8{0x60, 0xff, 0xc0, 0x0f}, 2, nil,
+		func(c8 *Chip8) bool { return c8.v[0] <= 0x0f }},
+	{"DRW Vx, Vy, nibble", []uint8{0xa0, 0x00, 0xd0, 0x05}, 2, nil,
+		func(c8 *Chip8) bool {
+			return c8.Gfx[0][0] == 1 && c8.Gfx[4][0] == 0 && c8.v[0xf] == 0
+		}},
+	{"DRW collision", []uint8{0xa0, 0x00, 0xd0, 0x05, 0xd0, 0x05}, 3, nil,
+		func(c8 *Chip8) bool { return c8.Gfx[0][0] == 0 && c8.v[0xf] == 1 }},
+	{"CLS", []uint8{0xa0, 0x00, 0xd0, 0x05, 0x00, 0xe0}, 3, nil,
+		func(c8 *Chip8) bool { return c8.Gfx == [DisplayWidth][DisplayHeight]uint8{} }},
+	{"SKP Vx", []uint8{0x60, 0x05, 0xe0, 0x9e, 0x61, 0x01, 0x62, 0x01}, 3,
+		func(c8 *Chip8, step int) { c8.SetKey(5, true) },
+		func(c8 *Chip8) bool { return c8.v[1] == 0 && c8.v[2] == 1 }},
+	{"SKNP Vx", []uint8{0x60, 0x05, 0xe0, 0xa1, 0x61, 0x01, 0x62, 0x01}, 3, nil,
+		func(c8 *Chip8) bool { return c8.v[1] == 0 && c8.v[2] == 1 }},
+	{"LD Vx, K", []uint8{0xf3, 0x0a}, 3,
+		func(c8 *Chip8, step int) { c8.SetKey(0xb, step == 1) },
+		func(c8 *Chip8) bool { return c8.v[3] == 0xb && c8.pc == 0x202 }},
+	{"LD DT, Vx, LD Vx, DT", []uint8{0x60, 0x05, 0xf0, 0x15, 0xf1, 0x07}, 3, nil,
+		func(c8 *Chip8) bool { return c8.v[1] == 5 && c8.DelayTimerValue() == 5 }},
+	{"LD ST, Vx", []uint8{0x60, 0x05, 0xf0, 0x18}, 2, nil,
+		func(c8 *Chip8) bool { return c8.SoundTimerValue() == 5 }},
+	{"ADD I, Vx", []uint8{0xa1, 0x00, 0x60, 0x05, 0xf0, 0x1e}, 3, nil,
+		func(c8 *Chip8) bool { return c8.i == 0x105 }},
+	{"LD F, Vx", []uint8{0x60, 0x0a, 0xf0, 0x29}, 2, nil,
+		func(c8 *Chip8) bool { return c8.i == 50 }},
+	{"LD B, Vx", []uint8{0x60, 0x7b, 0xa3, 0x00, 0xf0, 0x33}, 3, nil,
+		func(c8 *Chip8) bool {
+			return c8.mem[0x300] == 1 && c8.mem[0x301] == 2 && c8.mem[0x302] == 3
+		}},
+	{"LD [I], Vx, LD Vx, [I]", []uint8{
+		0x60, 0x01, 0x61, 0x02, 0xa3, 0x00, 0xf1, 0x55,
+		0x60, 0x00, 0x61, 0x00, 0xa3, 0x00, 0xf1, 0x65,
+	}, 8, nil,
+		func(c8 *Chip8) bool { return c8.v[0] == 1 && c8.v[1] == 2 }},
+}
+
+// SelfTest runs a built-in test of each instruction with the default quirks
+// and returns the results.
+func SelfTest() []SelfTestResult {
+	var results []SelfTestResult
+	for _, t := range selfTests {
+		c8 := New()
+		c8.timer.Stop()
+		c8.SetSeed(1)
+		if err := c8.LoadRomData(t.rom); err != nil {
+			results = append(results, SelfTestResult{t.name, err})
+			continue
+		}
+		var err error
+		for i := 0; i < t.steps && err == nil; i++ {
+			if t.input != nil {
+				t.input(c8, i)
+			}
+			if stepErr := c8.Step(); stepErr != nil {
+				err = fmt.Errorf("Failed after %d instructions: %v", i, stepErr)
+			}
+		}
+		if err == nil && !t.check(c8) {
+			err = fmt.Errorf("Unexpected state:\n%s", c8.DumpState())
+		}
+		results = append(results, SelfTestResult{t.name, err})
+	}
+	return results
+}
@@ -0,0 +1,136 @@
+package chip8
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// Frames sent by a FrameStream start with this header, followed by the
+// frame as returned by PackFrame.
+type FrameHeader struct {
+	Magic  [4]byte // "CH8F"
+	Width  uint16  // Big endian, like the rest of the header
+	Height uint16
+	Planes uint8
+}
+
+var frameMagic = [4]byte{'C', 'H', '8', 'F'}
+
+// How long a frame may take to send before the client is dropped, so that a
+// slow client doesn't hold up emulation.
+const frameWriteTimeout = 100 * time.Millisecond
+
+// PackFrame packs a display into one bitmap per plane, the first plane first.
+// Each bitmap holds the rows top to bottom, 8 pixels per byte with the
+// leftmost in the most significant bit.
+func PackFrame(gfx *[DisplayWidth][DisplayHeight]uint8) []byte {
+	rowBytes := DisplayWidth / 8
+	packed := make([]byte, maxPlanes*DisplayHeight*rowBytes)
+	for plane := 0; plane < maxPlanes; plane++ {
+		bitmap := packed[plane*DisplayHeight*rowBytes:]
+		for y := 0; y < DisplayHeight; y++ {
+			for x := 0; x < DisplayWidth; x++ {
+				if gfx[x][y]&(1<<plane) != 0 {
+					bitmap[y*rowBytes+x/8] |= 0x80 >> (x % 8)
+				}
+			}
+		}
+	}
+	return packed
+}
+
+// FrameStream sends frames to the clients of a listener, e.g. for external
+// viewers or recorders. Clients get the latest frame when they connect and
+// every frame sent after that.
+type FrameStream struct {
+	ln      net.Listener
+	mu      sync.Mutex
+	clients []net.Conn
+	last    []byte
+}
+
+// ListenFrames starts a FrameStream listening on a network address, as
+// accepted by net.Listen, e.g. "unix" and a path or "tcp" and "host:port".
+func ListenFrames(network, addr string) (*FrameStream, error) {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &FrameStream{ln: ln}
+	var blank [DisplayWidth][DisplayHeight]uint8
+	s.last = encodeFrame(&blank)
+	go s.accept()
+	return s, nil
+}
+
+// Addr returns the address listened on.
+func (s *FrameStream) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+func (s *FrameStream) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return // Closed
+		}
+		s.mu.Lock()
+		if s.send(conn, s.last) {
+			s.clients = append(s.clients, conn)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Send sends a frame to the connected clients. Clients that fail to receive
+// it in time are disconnected.
+func (s *FrameStream) Send(gfx *[DisplayWidth][DisplayHeight]uint8) {
+	frame := encodeFrame(gfx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = frame
+	n := 0
+	for _, conn := range s.clients {
+		if s.send(conn, frame) {
+			s.clients[n] = conn
+			n++
+		}
+	}
+	s.clients = s.clients[:n]
+}
+
+// Sends a frame to a client, closing the connection on failure.
+func (s *FrameStream) send(conn net.Conn, frame []byte) bool {
+	conn.SetWriteDeadline(time.Now().Add(frameWriteTimeout))
+	if _, err := conn.Write(frame); err != nil {
+		conn.Close()
+		return false
+	}
+	return true
+}
+
+// Close stops listening and disconnects the clients.
+func (s *FrameStream) Close() error {
+	err := s.ln.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.clients {
+		conn.Close()
+	}
+	s.clients = nil
+	return err
+}
+
+// Returns a frame with its header as sent to clients.
+func encodeFrame(gfx *[DisplayWidth][DisplayHeight]uint8) []byte {
+	header := FrameHeader{frameMagic, DisplayWidth, DisplayHeight, maxPlanes}
+	frame := make([]byte, 0, binary.Size(header)+maxPlanes*DisplayHeight*
+		DisplayWidth/8)
+	frame = append(frame, header.Magic[:]...)
+	frame = binary.BigEndian.AppendUint16(frame, header.Width)
+	frame = binary.BigEndian.AppendUint16(frame, header.Height)
+	frame = append(frame, header.Planes)
+	return append(frame, PackFrame(gfx)...)
+}
@@ -0,0 +1,68 @@
+package chip8
+
+// Returns masks of the registers an instruction reads and writes, bit n
+// standing for Vn.
+func (c8 *Chip8) registerUse(op uint16) (reads, writes uint16) {
+	x, y := uint16(1)<<(op>>8&0xf), uint16(1)<<(op>>4&0xf)
+	const vf = 1 << 0xf
+	switch op & 0xf000 {
+	case 0x3000, 0x4000, 0xe000:
+		return x, 0
+	case 0x5000, 0x9000:
+		return x | y, 0
+	case 0x6000, 0xc000:
+		return 0, x
+	case 0x7000:
+		return x, x
+	case 0x8000:
+		switch op & 0xf {
+		case 0x0:
+			return y, x
+		case 0x1, 0x2, 0x3:
+			if c8.Quirks.VFReset {
+				return x | y, x | vf
+			}
+			return x | y, x
+		case 0x4, 0x5, 0x7:
+			return x | y, x | vf
+		case 0x6, 0xe:
+			if c8.Quirks.Shift {
+				return x, x | vf
+			}
+			return y, x | vf
+		}
+	case 0xb000:
+		if c8.Quirks.Jump {
+			return x, 0
+		}
+		return 1, 0 // V0
+	case 0xd000:
+		return x | y, vf
+	case 0xf000:
+		switch op & 0xff {
+		case 0x07, 0x0a:
+			return 0, x
+		case 0x15, 0x18, 0x1e, 0x29, 0x33:
+			return x, 0
+		case 0x55:
+			return x<<1 - 1, 0 // V0 through Vx
+		case 0x65:
+			return 0, x<<1 - 1
+		}
+	}
+	return 0, 0
+}
+
+// Warns about registers read by op that weren't written since reset. Each
+// register is only reported once.
+func (c8 *Chip8) checkRegisters(op uint16) {
+	reads, writes := c8.registerUse(op)
+	if unset := reads &^ c8.written; unset != 0 {
+		for r := 0; r < len(c8.v); r++ {
+			if unset&(1<<r) != 0 {
+				c8.warn("V%X read before being written", r)
+			}
+		}
+	}
+	c8.written |= reads | writes
+}
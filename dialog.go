@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Programs showing a native file dialog, which print the chosen path.
+var fileDialogs = [][]string{
+	{"zenity", "--file-selection", "--title=Open ROM"},
+	{"kdialog", "--getopenfilename", ".", "--title", "Open ROM"},
+}
+
+// Asks for a ROM file with a native dialog. Reports false if no dialog is
+// available or none was chosen.
+func chooseRomFile() (string, bool) {
+	dialogs := fileDialogs
+	if runtime.GOOS == "darwin" {
+		dialogs = [][]string{{"osascript", "-e",
+			`POSIX path of (choose file with prompt "Open ROM")`}}
+	}
+	for _, args := range dialogs {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		out, err := exec.Command(args[0], args[1:]...).Output()
+		if err != nil {
+			// Cancelled, or the dialog couldn't be shown.
+			log.Printf("No ROM chosen: %v", err)
+			return "", false
+		}
+		path := strings.TrimSpace(string(out))
+		return path, path != ""
+	}
+	return "", false
+}
+
+// Returns the ROM given as argument or, without arguments and with dialog
+// set, the one chosen with choose. Empty if there is none.
+func romArg(args []string, dialog bool, choose func() (string, bool)) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	if dialog {
+		if path, ok := choose(); ok {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chip8-go/chip8"
+)
+
+func TestRomArg(t *testing.T) {
+	dir := t.TempDir()
+	chosen := filepath.Join(dir, "chosen.ch8")
+	err := os.WriteFile(chosen, []byte{0x00, 0xe0, 0x12, 0x02}, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "chosen.json"),
+		[]byte(`{"title": "Chosen"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		args    []string
+		dialog  bool
+		choice  string // Chosen in the dialog, empty if cancelled
+		want    string
+		choices int
+	}{
+		{"argument", []string{"game.ch8"}, true, chosen, "game.ch8", 0},
+		{"no dialog", nil, false, chosen, "", 0},
+		{"chosen", nil, true, chosen, chosen, 1},
+		{"cancelled", nil, true, "", "", 1},
+	}
+	for _, test := range tests {
+		choices := 0
+		choose := func() (string, bool) {
+			choices++
+			return test.choice, test.choice != ""
+		}
+		got := romArg(test.args, test.dialog, choose)
+		if got != test.want || choices != test.choices {
+			t.Errorf("%s: %q with %d dialogs, want %q with %d", test.name,
+				got, choices, test.want, test.choices)
+		}
+	}
+
+	// The chosen ROM is loaded as one given as argument.
+	romPath := romArg(nil, true, func() (string, bool) { return chosen, true })
+	info, err := readRomInfo(romPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.title() != "Chip-8 - Chosen" {
+		t.Errorf("title %q, want the one of the chosen ROM", info.title())
+	}
+	c8 := chip8.New()
+	if err := c8.LoadRom(romPath); err != nil {
+		t.Fatal(err)
+	}
+	if c8.RomSize() != 4 || c8.Peek(0x202) != 0x12 {
+		t.Errorf("loaded % x, want the chosen ROM", c8.DumpMemory(0x200, 0x204))
+	}
+}
@@ -2,11 +2,21 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/glfw/v3.2/glfw"
@@ -14,7 +24,119 @@ import (
 	"chip8-go/chip8"
 )
 
-const renderScale = 15
+var (
+	scale = flag.Int(
+		"scale", 15, "Screen pixels per Chip-8 pixel, overriding the window "+
+			"size saved on exit")
+	speed = flag.Int(
+		"speed", 0, "Instructions per second (0 runs as fast as possible)")
+	bellEnabled = flag.Bool(
+		"bell", true, "Ring the terminal bell when the sound timer is active")
+	autoTune = flag.Bool(
+		"auto-tune", false, "With -speed, lower the speed while the "+
+			"machine can't keep up, and report the speed it settles at")
+	timing = flag.String(
+		"timing", "flat", "Instruction timing: flat runs -speed instructions "+
+			"a second, accurate takes the time each takes on the COSMAC VIP")
+	tearing = flag.Bool(
+		"tearing", false, "Emulate COSMAC VIP sprite tearing (requires -speed)")
+	coalesce = flag.Bool(
+		"coalesce", false, "Show the display once per frame instead of after "+
+			"every draw, hiding sprites erased and redrawn within a frame "+
+			"(implied by -speed)")
+	presentRate = flag.Int(
+		"present-rate", 0, "Show the display at most this many times a "+
+			"second, gathering the draws in between (0 shows every draw)")
+	drawCap = flag.Int(
+		"draw-cap", 0, "Show the display at most this many times a frame, "+
+			"for stress testing, and report the draws dropped on exit")
+	pastEnd = flag.String(
+		"past-end", "warn", "When the program runs past the end of the ROM: "+
+			"warn, ignore or halt")
+	checkSprites = flag.Bool(
+		"check-sprites", false, "Warn when DRW reads past the end of memory")
+	checkAlignment = flag.Bool(
+		"check-alignment", false, "Warn when executing at an odd address")
+	checkRegisters = flag.Bool(
+		"check-registers", false, "Warn when reading a register before it "+
+			"was written")
+	idleDetection = flag.Bool(
+		"idle", false, "With -speed, end a frame early when the program "+
+			"spins waiting for the delay timer, to save CPU")
+	duration = flag.Duration(
+		"duration", 0, "Run headless for this much emulated time, then print "+
+			"the frame hash and exit (with the quirks found wrong, only for "+
+			"the bundled testdata/quirks.ch8 for now)")
+	verifyTrace = flag.String(
+		"verify-trace", "", "With -duration, compare the state after each "+
+			"frame with the trace in this file, recording it if the file "+
+			"doesn't exist, and report the first frame that differs")
+	watchRom = flag.Bool(
+		"watch", false, "Reload and restart the ROM when the file changes")
+	interpreter = flag.String(
+		"interpreter", "", "Image of interpreter code to load below 0x200, "+
+			"under the font")
+	openDialog = flag.Bool(
+		"dialog", false, "Choose the ROM with a file dialog when none is "+
+			"given (needs zenity, kdialog or macOS)")
+	splash = flag.Bool(
+		"splash", false, "Show a splash screen instead of the usage when no "+
+			"ROM is given")
+	braille = flag.Bool(
+		"braille", false, "Draw the display in the terminal using braille "+
+			"characters instead of opening a window (no input)")
+	frameDir = flag.String(
+		"frames", "", "With -duration, write each drawn frame to this "+
+			"directory as a numbered PNG")
+	hotspots = flag.String(
+		"hotspots", "", "Write the most executed instructions to this file on exit")
+	batch = flag.Bool(
+		"batch", false, "Run each ROM in the directory given instead of a "+
+			"ROM headlessly for -duration (10s if not given) and print a "+
+			"summary")
+	recordRandom = flag.String(
+		"record-random", "", "Record the random numbers used to this file "+
+			"on exit, for -replay-random")
+	replayRandom = flag.String(
+		"replay-random", "", "Use the random numbers recorded in this file")
+	selfTest = flag.Bool(
+		"selftest", false, "Run the built-in instruction tests and exit")
+	bezel = flag.Int(
+		"bezel", 0, "Width of the border around the display, in Chip-8 pixels")
+	bezelColor = flag.String(
+		"bezel-color", "000000", "Color of the border, as hex RRGGBB")
+	profile = flag.String(
+		"profile", "", "Quirks profile: default, vip or schip (detected if "+
+			"not given, only for the bundled testdata/quirks.ch8 for now)")
+	restartOnCrash = flag.Bool(
+		"restart-on-crash", false, "Restart the ROM instead of exiting when "+
+			"it fails, up to 5 times a minute")
+	quirkList = flag.String(
+		"quirk", "", "Comma separated quirks to enable on top of the profile, "+
+			"e.g. shift,loadstore")
+	loopMode = flag.String(
+		"loop", "wait", "Event loop: wait sleeps until there is input or "+
+			"work to do, poll polls for input continuously for the lowest "+
+			"latency at the cost of CPU")
+	stickyKeys = flag.Bool(
+		"sticky-keys", false, "Keys stay pressed until pressed again, "+
+			"instead of having to be held")
+	inputProfile = flag.String(
+		"input", "standard", "Input profile: standard, or hp48 to also use "+
+			"the numeric keypad as the HP48 keys of SCHIP")
+	paletteName = flag.String(
+		"palette", "default", "Display colors: default, xochip, amber, green "+
+			"or a comma separated list of hex RRGGBB, background first")
+	streamAddr = flag.String(
+		"stream", "", "Stream the display to clients connecting to this "+
+			"address, unix:PATH or HOST:PORT")
+	overlayKeyName = flag.String(
+		"overlay-key", "F1", "Key cycling through the debug overlays: off, "+
+			"registers, keypad, memory, grid, font (F1-F12 or a letter)")
+	powerOn = flag.Duration(
+		"power-on", 0, "Fade the display in over this duration before "+
+			"emulation starts")
+)
 
 func init() {
 	runtime.LockOSThread()
@@ -28,13 +150,103 @@ func main() {
 }
 
 func run() error {
-	if len(os.Args) != 2 {
-		return fmt.Errorf("Usage: %s <rom file>\n", os.Args[0])
+	flag.Parse()
+	if *selfTest {
+		return runSelfTest()
+	}
+	if *batch {
+		if flag.NArg() != 1 {
+			return fmt.Errorf("Usage: %s -batch [flags] <rom directory>\n",
+				os.Args[0])
+		}
+		return runBatch(flag.Arg(0))
+	}
+	romPath := romArg(flag.Args(), *openDialog, chooseRomFile)
+	showSplash := romPath == "" && *splash
+	if flag.NArg() > 1 || (romPath == "" && !showSplash) {
+		return fmt.Errorf("Usage: %s [flags] <rom file>\n", os.Args[0])
+	}
+	info := &romInfo{}
+	if !showSplash {
+		var err error
+		if info, err = readRomInfo(romPath); err != nil {
+			return err
+		}
+	}
+	info.apply(setFlags())
+	palette, err := parsePalette(*paletteName)
+	if err != nil {
+		return err
+	}
+	if err := info.applyColors(palette, setFlags()); err != nil {
+		return err
+	}
+	if err := setInputProfile(); err != nil {
+		return err
+	}
+	if err := setOverlayKey(); err != nil {
+		return err
+	}
+	if *loopMode != "wait" && *loopMode != "poll" {
+		return fmt.Errorf("Unknown loop mode %q", *loopMode)
 	}
+	if *timing != "flat" && *timing != "accurate" {
+		return fmt.Errorf("Unknown timing %q", *timing)
+	}
+	pastEndPolicy, ok := pastEndPolicies[*pastEnd]
+	if !ok {
+		return fmt.Errorf("Unknown past end behavior %q", *pastEnd)
+	}
+	speeds := speedCheck{log: log.Default()}
+	speeds.check(*speed)
 	c8 := chip8.New()
-	if err := c8.LoadRom(os.Args[1]); err != nil {
+	c8.CheckSprites = *checkSprites
+	c8.CheckAlignment = *checkAlignment
+	c8.CheckRegisters = *checkRegisters
+	c8.IdleDetection = *idleDetection
+	c8.StickyKeys = *stickyKeys
+	c8.PastEnd = pastEndPolicy
+	c8.Warn = func(msg string) { log.Print(msg) }
+	if *interpreter != "" {
+		if err := loadInterpreter(c8); err != nil {
+			return err
+		}
+	}
+	if showSplash {
+		err = c8.LoadRomData(splashRom)
+	} else {
+		err = c8.LoadRom(romPath)
+	}
+	if err != nil {
+		return err
+	}
+	if err := setProfile(c8); err != nil {
 		return err
 	}
+	var onExit shutdown
+	defer onExit.run()
+	if *hotspots != "" {
+		c8.EnableProfiling()
+		onExit.add("hotspots", func() error { return writeHotspots(c8) })
+	}
+	if *replayRandom != "" {
+		if err := loadRandomLog(c8); err != nil {
+			return err
+		}
+	}
+	if *recordRandom != "" {
+		c8.RecordRandom()
+		onExit.add("random log", func() error { return writeRandomLog(c8) })
+	}
+	if *duration > 0 && *verifyTrace != "" {
+		return runTraceCheck(c8)
+	}
+	if *duration > 0 {
+		return runHeadless(c8, palette)
+	}
+	if *braille {
+		return runTerminal(c8, palette)
+	}
 
 	if err := glfw.Init(); err != nil {
 		return err
@@ -46,136 +258,1012 @@ func run() error {
 	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
 	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
 
-	width := chip8.DisplayWidth * renderScale
-	height := chip8.DisplayHeight * renderScale
-	window, err := glfw.CreateWindow(width, height, "Chip-8", nil, nil)
+	if *bezel < 0 {
+		return errors.New("Bezel width must not be negative")
+	}
+	borderColor, err := parseColor(*bezelColor)
+	if err != nil {
+		return err
+	}
+	if *scale < 1 {
+		return errors.New("Scale must be at least 1")
+	}
+	statePath, err := windowStatePath()
+	if err != nil {
+		return err
+	}
+	state, err := readWindowState(statePath)
+	if err != nil {
+		return err
+	}
+	restore := state.Width > 0 && state.Height > 0
+	if !restore || setFlags()["scale"] {
+		state.Width = (chip8.DisplayWidth + 2**bezel) * *scale
+		state.Height = (chip8.DisplayHeight + 2**bezel) * *scale
+	}
+	if restore {
+		state.bounds = state.clamp(monitorBounds())
+	}
+	// Hidden until placed, so that it doesn't jump to the saved position.
+	glfw.WindowHint(glfw.Visible, glfw.False)
+	window, err := glfw.CreateWindow(
+		state.Width, state.Height, info.title(), nil, nil)
 	if err != nil {
 		return err
 	}
+	if restore {
+		window.SetPos(state.X, state.Y)
+	}
+	window.Show()
+	state.track(window)
+	onExit.add("window state", state.save)
 
 	window.MakeContextCurrent()
 
-	vertex, err := glSetup()
+	screen, err := glSetup(*bezel, palette)
 	if err != nil {
 		return err
 	}
 
-	window.SetKeyCallback(keyHandler(c8))
-	window.SetSizeCallback(resizeHandler)
+	picker := newPalettePicker(*paletteName)
+	onExit.add("palette", func() error {
+		if showSplash {
+			return nil
+		}
+		return info.savePalette(picker)
+	})
+	window.SetKeyCallback(keyHandler(c8, screen, picker))
+	// The framebuffer size is in pixels, which differs from the window size in
+	// screen coordinates on HiDPI displays.
+	window.SetFramebufferSizeCallback(resizeHandler)
+	fbWidth, fbHeight := window.GetFramebufferSize()
+	resizeHandler(window, fbWidth, fbHeight)
+	var powerOnHook func()
+	if *powerOn > 0 {
+		powerOnHook = func() { fadeIn(window, screen, *powerOn) }
+	}
+	runner := startEmulation(c8, powerOnHook)
+
+	beep := &bell{w: os.Stdout}
+	if !*bellEnabled {
+		beep.w = io.Discard
+	}
+
+	gfx := &c8.Gfx
+	if runner != nil {
+		gfx = &runner.Display
+	}
+	lastStats := time.Now()
+	stats, title := "", info.title()
 
-	gl.ClearColor(.1, .1, .1, 0)
+	waitForInput := inputWaiter(*loopMode, c8, func() {
+		// Wakes up every frame for the timers to keep running.
+		glfw.WaitEventsTimeout(1.0 / chip8.FrameRate)
+	}, window.ShouldClose)
+	restarts := supervisor{max: 5, window: time.Minute}
+	tuned := false // Whether the auto-tuned speed was reported
+	present := presenter{coalesce: *coalesce}
+	if *presentRate > 0 {
+		present.interval = time.Second / time.Duration(*presentRate)
+	}
+	if *drawCap > 0 {
+		present.cap = *drawCap
+		defer func() {
+			log.Printf("Draws dropped by -draw-cap: %d", present.dropped)
+		}()
+	}
+	var watch *watcher
+	if *watchRom && !showSplash {
+		watch = newWatcher(romPath)
+	}
+	var stream *chip8.FrameStream
+	if *streamAddr != "" {
+		if stream, err = listenFrames(*streamAddr); err != nil {
+			return err
+		}
+		defer stream.Close()
+	}
+	border := glColor(borderColor)
+	gl.ClearColor(border[0], border[1], border[2], 0)
 	for !window.ShouldClose() {
-		if err := c8.Cycle(glfw.WaitEvents); err != nil {
+		var err error
+		if runner != nil {
+			_, err = runner.Advance(waitForInput)
+		} else {
+			err = c8.Cycle(waitForInput)
+		}
+		if err != nil && *restartOnCrash {
+			err = restarts.restart(c8, err, time.Now())
+		}
+		if err != nil {
 			return err
 		}
-		if c8.Draw {
-			gl.Clear(gl.COLOR_BUFFER_BIT)
-			n := fillVerticesToDraw(c8, vertex)
-			gl.BufferSubData(gl.ELEMENT_ARRAY_BUFFER, 0, n*4, gl.Ptr(vertex))
-			gl.DrawElements(gl.TRIANGLES, int32(n), gl.UNSIGNED_INT, gl.PtrOffset(0))
+		if runner != nil && runner.AutoTune != nil && !tuned {
+			if cycles, ok := runner.AutoTune.Settled(); ok {
+				ips := cycles * chip8.FrameRate
+				log.Printf("Speed settled at %d instructions per second", ips)
+				speeds.check(ips)
+				tuned = true
+			}
+		}
+		if watch != nil && watch.changed(time.Now()) {
+			reloadRom(c8, romPath)
+		}
+		if present.update(c8.Draw, c8.Frame(), time.Now()) || screen.dirty {
+			if screen.overlay == overlayFont {
+				font := c8.FontDisplay()
+				screen.draw(&font)
+			} else {
+				screen.draw(gfx)
+			}
 			window.SwapBuffers()
+			if stream != nil {
+				stream.Send(gfx)
+			}
 		}
-		glfw.PollEvents()
+		beep.update(c8.SoundActive())
+		if runner != nil && time.Since(lastStats) >= time.Second {
+			stats = fmt.Sprintf(" (%.0f IPS)", runner.MeasuredSpeed())
+			lastStats = time.Now()
+		}
+		if t := info.title() + stats + screen.overlay.text(c8); t != title {
+			window.SetTitle(t)
+			title = t
+		}
+		switch {
+		case *loopMode == "poll":
+			glfw.PollEvents()
+		case c8.Stopped(), c8.Halted():
+			glfw.WaitEvents()
+		case runner != nil && runner.Idle() > 0:
+			glfw.WaitEventsTimeout(runner.Idle().Seconds())
+		default:
+			glfw.PollEvents()
+		}
+	}
+	return nil
+}
+
+// Runs the power-on sequence, if any, then returns the runner emulating c8 as
+// given by flags, nil to emulate with Chip8.Cycle. The runner is created
+// after the sequence so that emulation and its clock start once it completes.
+func startEmulation(c8 *chip8.Chip8, powerOn func()) *chip8.Runner {
+	if powerOn != nil {
+		powerOn()
+	}
+	if *speed == 0 && *timing != "accurate" {
+		return nil
+	}
+	runner := chip8.NewRunner(c8, *speed)
+	runner.Tearing = *tearing
+	if *timing == "accurate" {
+		runner.Timing = chip8.TimingAccurate
+	}
+	if *autoTune {
+		runner.AutoTune = &chip8.AutoTune{Target: runner.CyclesPerFrame}
+	}
+	return runner
+}
+
+// Returns the function called by Fx0A to wait for input in the loop mode. It
+// waits for events with wait, and stops emulation to return from Fx0A once
+// closing reports true. When polling there is none, Fx0A doesn't block and is
+// executed again until a key is pressed.
+func inputWaiter(
+	mode string, c8 *chip8.Chip8, wait func(), closing func() bool) func() {
+	if mode != "wait" {
+		return nil
+	}
+	return func() {
+		wait()
+		if closing() {
+			c8.Stop()
+		}
+	}
+}
+
+// Starts streaming frames on addr, a Unix socket as unix:PATH or else a TCP
+// address.
+func listenFrames(addr string) (*chip8.FrameStream, error) {
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network, addr = "unix", strings.TrimPrefix(addr, "unix:")
+	}
+	stream, err := chip8.ListenFrames(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("Error listening for frame stream: %v", err)
+	}
+	log.Printf("Streaming frames on %s", stream.Addr())
+	return stream, nil
+}
+
+// Decides when to show the display. With coalescing, changes are shown once
+// per frame so that the intermediate states of a frame, such as a sprite
+// erased to be redrawn elsewhere, aren't shown and flicker.
+//
+// With an interval, changes are instead shown at that cadence at most,
+// whatever the rate of draws.
+//
+// A cap limits how many times the display is shown per frame, to stress test
+// rendering. Draws past it are dropped, shown along with the next frame.
+type presenter struct {
+	coalesce bool
+	interval time.Duration
+	cap      int       // Maximum shows per frame, 0 for no limit
+	pending  bool      // Whether there are changes not shown
+	frame    uint64    // Frame at the previous update
+	shown    time.Time // When the display was last shown
+	shows    int       // Times shown in the current frame
+	dropped  uint64    // Draws dropped by the cap
+}
+
+// Reports whether to show the display, given whether it changed, the current
+// frame and time.
+func (p *presenter) update(changed bool, frame uint64, now time.Time) bool {
+	p.pending = p.pending || changed
+	boundary := frame != p.frame
+	p.frame = frame
+	if boundary {
+		p.shows = 0
+	}
+	switch {
+	case !p.pending:
+		return false
+	case p.interval > 0:
+		if now.Sub(p.shown) < p.interval {
+			return false
+		}
+	case p.coalesce && !boundary:
+		return false
+	}
+	if p.cap > 0 && p.shows >= p.cap {
+		if changed {
+			p.dropped++
+		}
+		return false
+	}
+	p.shows++
+	p.pending = false
+	p.shown = now
+	return true
+}
+
+// Limits restarts after crashes to max within window, so that a ROM failing
+// right away doesn't restart in a tight loop.
+type supervisor struct {
+	max      int
+	window   time.Duration
+	restarts []time.Time
+}
+
+// Reports whether a restart is allowed at now, counting it if so.
+func (s *supervisor) allow(now time.Time) bool {
+	for len(s.restarts) > 0 && now.Sub(s.restarts[0]) >= s.window {
+		s.restarts = s.restarts[1:]
+	}
+	if len(s.restarts) >= s.max {
+		return false
+	}
+	s.restarts = append(s.restarts, now)
+	return true
+}
+
+// Resets c8 after it failed with err if a restart is allowed at now, else
+// returns err.
+func (s *supervisor) restart(c8 *chip8.Chip8, err error, now time.Time) error {
+	if !s.allow(now) {
+		return err
+	}
+	log.Printf("%v, restarting\n%s", err, c8.DumpState())
+	c8.Reset()
+	return nil
+}
+
+// Output, such as recordings, to be completed on exit. Hooks are run in
+// reverse order of registration, also when exiting due to an error.
+type shutdown []shutdownHook
+
+type shutdownHook struct {
+	name     string
+	finalize func() error
+}
+
+func (s *shutdown) add(name string, finalize func() error) {
+	*s = append(*s, shutdownHook{name, finalize})
+}
+
+func (s *shutdown) run() {
+	for i := len(*s) - 1; i >= 0; i-- {
+		hook := (*s)[i]
+		if err := hook.finalize(); err != nil {
+			log.Printf("Error finalizing %s: %v", hook.name, err)
+		}
+	}
+}
+
+func writeHotspots(c8 *chip8.Chip8) error {
+	f, err := os.Create(*hotspots)
+	if err != nil {
+		return err
+	}
+	if err := c8.WriteHotspots(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Replays the random numbers recorded in the file given by flag.
+func loadRandomLog(c8 *chip8.Chip8) error {
+	f, err := os.Open(*replayRandom)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash, data, err := chip8.ReadRandomLog(f)
+	if err != nil {
+		return err
+	}
+	if hash != c8.RomHash() {
+		log.Printf("Warning: random numbers were recorded with another ROM")
+	}
+	c8.ReplayRandom(data)
+	return nil
+}
+
+func writeRandomLog(c8 *chip8.Chip8) error {
+	f, err := os.Create(*recordRandom)
+	if err != nil {
+		return err
+	}
+	if err := c8.WriteRandomLog(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runSelfTest() error {
+	failed := 0
+	for _, result := range chip8.SelfTest() {
+		if result.Err != nil {
+			fmt.Printf("FAIL %s: %v\n", result.Name, result.Err)
+			failed++
+		} else {
+			fmt.Printf("PASS %s\n", result.Name)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d self tests failed", failed)
+	}
+	return nil
+}
+
+// Warns, once, about a speed far outside the speeds programs are written
+// for, whether set by flag or settled at by auto-tuning.
+type speedCheck struct {
+	log    *log.Logger
+	warned bool
+}
+
+func (s *speedCheck) check(ips int) {
+	if ips == 0 || s.warned {
+		return
+	}
+	if ips < chip8.MinTypicalSpeed || ips > chip8.MaxTypicalSpeed {
+		s.log.Printf("Warning: %d instructions per second is outside the "+
+			"typical range of %d to %d, programs may be unplayable",
+			ips, chip8.MinTypicalSpeed, chip8.MaxTypicalSpeed)
+		s.warned = true
+	}
+}
+
+// Runs for the time given by the duration flag without opening a window.
+func runHeadless(c8 *chip8.Chip8, palette chip8.Palette) error {
+	ips := *speed
+	if ips == 0 {
+		ips = chip8.DefaultSpeed
+	}
+	runner := chip8.NewRunner(c8, ips)
+	if *timing == "accurate" {
+		runner.Timing = chip8.TimingAccurate
+	}
+	if *frameDir != "" {
+		if err := os.MkdirAll(*frameDir, 0755); err != nil {
+			return err
+		}
+	}
+	frames, err := runFrames(runner, palette, *duration, *frameDir)
+	fmt.Printf("frames=%d cycles=%d hash=%016x\n",
+		frames, runner.Cycles(), c8.FrameHash())
+	for _, diagnosis := range c8.DiagnoseQuirks() {
+		fmt.Println(diagnosis)
+	}
+	return err
+}
+
+var pastEndPolicies = map[string]chip8.PastEndPolicy{
+	"warn":   chip8.PastEndWarn,
+	"ignore": chip8.PastEndIgnore,
+	"halt":   chip8.PastEndHalt,
+}
+
+// Runs the ROMs in dir and prints a table of the results.
+func runBatch(dir string) error {
+	d, ips := *duration, *speed
+	if d == 0 {
+		d = 10 * time.Second
+	}
+	if ips == 0 {
+		ips = chip8.DefaultSpeed
+	}
+	results, err := chip8.RunBatch(dir, d, ips)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ROM\tRESULT\tFRAMES\tHASH\tERROR")
+	for _, res := range results {
+		msg := ""
+		if res.Err != nil {
+			msg = res.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%016x\t%s\n",
+			res.Name, res.Status, res.Frames, res.Hash, msg)
+	}
+	return w.Flush()
+}
+
+// Runs for the time given by the duration flag with a fixed seed, and records
+// the state trace to the file given by flag, or compares it with the trace
+// there.
+func runTraceCheck(c8 *chip8.Chip8) error {
+	c8.SetSeed(1)
+	ips := *speed
+	if ips == 0 {
+		ips = chip8.DefaultSpeed
+	}
+	frames := int(*duration * chip8.FrameRate / time.Second)
+	trace, err := chip8.NewRunner(c8, ips).TraceStates(frames)
+	if err != nil {
+		log.Printf("Stopped after %d frames: %v", len(trace), err)
+	}
+	data, err := os.ReadFile(*verifyTrace)
+	if errors.Is(err, os.ErrNotExist) {
+		var b strings.Builder
+		for _, h := range trace {
+			fmt.Fprintf(&b, "%016x\n", h)
+		}
+		log.Printf("Recorded trace of %d frames", len(trace))
+		return os.WriteFile(*verifyTrace, []byte(b.String()), 0644)
+	} else if err != nil {
+		return err
+	}
+	var golden chip8.StateTrace
+	for _, line := range strings.Fields(string(data)) {
+		h, err := strconv.ParseUint(line, 16, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid trace file: %v", err)
+		}
+		golden = append(golden, h)
+	}
+	if frame := golden.FirstDivergence(trace); frame >= 0 {
+		return fmt.Errorf("State diverges from the trace at frame %d", frame)
+	}
+	fmt.Printf("State matches the trace for %d frames\n", len(trace))
+	return nil
+}
+
+// Runs the frames of d and returns how many were run. Unless dir is empty,
+// the frames drawn are written to it.
+func runFrames(runner *chip8.Runner, palette chip8.Palette, d time.Duration,
+	dir string) (int, error) {
+	frames := 0
+	frame := time.Second / chip8.FrameRate
+	for ; time.Duration(frames+1)*frame <= d; frames++ {
+		if err := runner.RunFrame(nil); err != nil {
+			return frames, err
+		}
+		if runner.C8.Draw && dir != "" {
+			err := writeFrame(dir, frames, palette.Image(&runner.Display))
+			if err != nil {
+				return frames, err
+			}
+		}
+	}
+	return frames, nil
+}
+
+// Writes the image of a frame to dir, named after its number.
+func writeFrame(dir string, n int, img image.Image) error {
+	f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%06d.png", n)))
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Loads the interpreter image given by flag.
+func loadInterpreter(c8 *chip8.Chip8) error {
+	data, err := os.ReadFile(*interpreter)
+	if err != nil {
+		return fmt.Errorf("Error reading interpreter image: %v", err)
+	}
+	return c8.LoadInterpreter(data)
+}
+
+// Applies the quirks profile given by flag, or the one known to be needed by
+// the loaded ROM, then the quirks given by flag on top.
+func setProfile(c8 *chip8.Chip8) error {
+	name := *profile
+	if name == "" {
+		if known, ok := chip8.LookupProfile(c8.RomHash()); ok {
+			log.Printf("Using quirks profile %s for known ROM", known)
+			name = known
+		}
+	}
+	if name != "" {
+		quirks, ok := chip8.Profiles[name]
+		if !ok {
+			return fmt.Errorf("Unknown quirks profile %q", name)
+		}
+		c8.Quirks = quirks
+	}
+	if *quirkList != "" {
+		return c8.Quirks.Enable(*quirkList)
 	}
 	return nil
 }
 
+// Rings the terminal bell once each time the sound timer is activated, rather
+// than continuously for as long as it is active.
+type bell struct {
+	w      io.Writer
+	active bool
+}
+
+func (b *bell) update(active bool) {
+	if active && !b.active {
+		fmt.Fprint(b.w, "\a")
+	}
+	b.active = active
+}
+
 func resizeHandler(w *glfw.Window, width, height int) {
 	gl.Viewport(0, 0, int32(width), int32(height))
 }
 
-func keyHandler(c8 *chip8.Chip8) glfw.KeyCallback {
+// Keypad    =>  Keyboard
+// |1|2|3|C|     |1|2|3|4|
+// |4|5|6|D|     |Q|W|E|R|
+// |7|8|9|E|     |A|S|D|F|
+// |A|0|B|F|     |Z|X|C|V|
+var keymap = map[glfw.Key]uint8{
+	glfw.Key1: 0x1, glfw.Key2: 0x2, glfw.Key3: 0x3, glfw.Key4: 0xC,
+	glfw.KeyQ: 0x4, glfw.KeyW: 0x5, glfw.KeyE: 0x6, glfw.KeyR: 0xD,
+	glfw.KeyA: 0x7, glfw.KeyS: 0x8, glfw.KeyD: 0x9, glfw.KeyF: 0xE,
+	glfw.KeyZ: 0xA, glfw.KeyX: 0x0, glfw.KeyC: 0xB, glfw.KeyV: 0xF,
+}
+
+// The keys of the HP48 calculator as mapped by CHIP-48 and SCHIP, on the
+// numeric keypad which is laid out like them. Enter stands in for the HP48's
+// space key.
+//
+// Keypad    =>  HP48
+// |1|2|3|C|     |7|8|9|/|
+// |4|5|6|D|     |4|5|6|*|
+// |7|8|9|E|     |1|2|3|-|
+// |A|0|B|F|     |0|.|_|+|
+var hp48Keymap = map[glfw.Key]uint8{
+	glfw.KeyKP7: 0x1, glfw.KeyKP8: 0x2, glfw.KeyKP9: 0x3, glfw.KeyKPDivide: 0xC,
+	glfw.KeyKP4: 0x4, glfw.KeyKP5: 0x5, glfw.KeyKP6: 0x6, glfw.KeyKPMultiply: 0xD,
+	glfw.KeyKP1: 0x7, glfw.KeyKP2: 0x8, glfw.KeyKP3: 0x9, glfw.KeyKPSubtract: 0xE,
+	glfw.KeyKP0: 0xA, glfw.KeyKPDecimal: 0x0, glfw.KeyKPEnter: 0xB, glfw.KeyKPAdd: 0xF,
+}
+
+// Input profiles selectable with -input, mapping more keys on top of keymap.
+var inputProfiles = map[string]map[glfw.Key]uint8{
+	"standard": {},
+	"hp48":     hp48Keymap,
+}
+
+// Adds the keys of the input profile given by flag to keymap.
+func setInputProfile() error {
+	keys, ok := inputProfiles[*inputProfile]
+	if !ok {
+		return fmt.Errorf("Unknown input profile %q", *inputProfile)
+	}
+	for key, k := range keys {
+		keymap[key] = k
+	}
+	return nil
+}
+
+// Sets overlayKey from the -overlay-key flag.
+func setOverlayKey() error {
+	name := strings.ToUpper(*overlayKeyName)
+	n, err := strconv.Atoi(strings.TrimPrefix(name, "F"))
+	switch {
+	case strings.HasPrefix(name, "F") && err == nil && n >= 1 && n <= 12:
+		overlayKey = glfw.KeyF1 + glfw.Key(n-1)
+	case len(name) == 1 && name[0] >= 'A' && name[0] <= 'Z':
+		overlayKey = glfw.KeyA + glfw.Key(name[0]-'A')
+	default:
+		return fmt.Errorf("Invalid overlay key %q", *overlayKeyName)
+	}
+	if _, ok := keymap[overlayKey]; ok {
+		return fmt.Errorf("Overlay key %q is a keypad key", *overlayKeyName)
+	}
+	switch overlayKey {
+	case glfw.KeyF6, glfw.KeyF7, glfw.KeyF8, glfw.KeyF9, glfw.KeyF10,
+		glfw.KeyG, glfw.KeyP, glfw.KeyT:
+		return fmt.Errorf("Overlay key %q is a hotkey", *overlayKeyName)
+	}
+	return nil
+}
+
+// Key cycling through the debug overlays.
+var overlayKey = glfw.KeyF1
+
+// A debug overlay. Without text rendering, the information overlays are shown
+// in the window title.
+type overlay int
+
+const (
+	overlayOff overlay = iota
+	overlayRegisters
+	overlayKeypad
+	overlayMemory
+	overlayGrid
+	overlayFont // Shows the font glyphs instead of the display
+	numOverlays
+)
+
+var overlayNames = [numOverlays]string{
+	"off", "registers", "keypad", "memory", "grid", "font",
+}
+
+func (o overlay) String() string {
+	return overlayNames[o]
+}
+
+// Returns the overlay following o, in a cycle.
+func (o overlay) next() overlay {
+	return (o + 1) % numOverlays
+}
+
+// Returns the text shown by an information overlay, empty for the others.
+func (o overlay) text(c8 *chip8.Chip8) string {
+	ctx := c8.SaveContext()
+	var b strings.Builder
+	switch o {
+	case overlayRegisters:
+		fmt.Fprintf(&b, " PC=%03x I=%03x V=% x", ctx.PC, ctx.I, ctx.V)
+	case overlayKeypad:
+		b.WriteString(" Keys:")
+		for k := uint8(0); k < 0x10; k++ {
+			if c8.KeyPressed(k) {
+				fmt.Fprintf(&b, " %X", k)
+			}
+		}
+	case overlayMemory:
+		fmt.Fprintf(&b, " [I=%03x]", ctx.I)
+		for i := uint16(0); i < 8; i++ {
+			fmt.Fprintf(&b, " %02x", c8.Peek(ctx.I+i))
+		}
+	}
+	return b.String()
+}
+
+// Updates the keypad for a key event, reporting whether the key is a keypad
+// key. Key state only follows Press and Release, so that it reflects the
+// physical state of the key.
+func keypadEvent(c8 *chip8.Chip8, key glfw.Key, action glfw.Action) bool {
+	k, ok := keymap[key]
+	if !ok {
+		return false
+	}
+	switch action {
+	case glfw.Press:
+		c8.SetKey(k, true)
+	case glfw.Release:
+		c8.SetKey(k, false)
+	case glfw.Repeat:
+		// Sent while held, the key is already pressed.
+	}
+	return true
+}
+
+func keyHandler(
+	c8 *chip8.Chip8, screen *screen, picker *palettePicker) glfw.KeyCallback {
+	pane := false // Whether the disassembly pane is shown
 	return func(
 		window *glfw.Window, key glfw.Key, scancode int,
 		action glfw.Action, mods glfw.ModifierKey) {
-		// Keypad    =>  Keyboard
-		// |1|2|3|C|     |1|2|3|4|
-		// |4|5|6|D|     |Q|W|E|R|
-		// |7|8|9|E|     |A|S|D|F|
-		// |A|0|B|F|     |Z|X|C|V|
-		switch action {
-		case glfw.Press:
-			switch key {
-			case glfw.Key1:
-				c8.Key[0x1] = true
-			case glfw.Key2:
-				c8.Key[0x2] = true
-			case glfw.Key3:
-				c8.Key[0x3] = true
-			case glfw.Key4:
-				c8.Key[0xC] = true
-			case glfw.KeyQ:
-				c8.Key[0x4] = true
-			case glfw.KeyW:
-				c8.Key[0x5] = true
-			case glfw.KeyE:
-				c8.Key[0x6] = true
-			case glfw.KeyR:
-				c8.Key[0xD] = true
-			case glfw.KeyA:
-				c8.Key[0x7] = true
-			case glfw.KeyS:
-				c8.Key[0x8] = true
-			case glfw.KeyD:
-				c8.Key[0x9] = true
-			case glfw.KeyF:
-				c8.Key[0xE] = true
-			case glfw.KeyZ:
-				c8.Key[0xA] = true
-			case glfw.KeyX:
-				c8.Key[0x0] = true
-			case glfw.KeyC:
-				c8.Key[0xB] = true
-			case glfw.KeyV:
-				c8.Key[0xF] = true
-			case glfw.KeyEscape:
-				window.SetShouldClose(true)
+		if keypadEvent(c8, key, action) {
+			return
+		}
+		if action != glfw.Press {
+			return
+		}
+		switch key {
+		case glfw.KeyEscape:
+			window.SetShouldClose(true)
+		case glfw.KeyF7:
+			writeMemoryDump(c8)
+		case glfw.KeyF8:
+			writeBugReport(c8)
+		case glfw.KeyG:
+			screen.grid = !screen.grid
+			screen.dirty = true
+		case overlayKey:
+			screen.overlay = screen.overlay.next()
+			screen.dirty = true
+			log.Printf("Overlay %s", screen.overlay)
+		case glfw.KeyF9:
+			picker.next()
+			screen.palette, _ = parsePalette(picker.name())
+			screen.dirty = true
+			log.Printf("Palette %s", picker.name())
+		case glfw.KeyF6:
+			pane = !pane
+			if pane {
+				printDisassembly(c8)
 			}
-		case glfw.Release:
-			switch key {
-			case glfw.Key1:
-				c8.Key[0x1] = false
-			case glfw.Key2:
-				c8.Key[0x2] = false
-			case glfw.Key3:
-				c8.Key[0x3] = false
-			case glfw.Key4:
-				c8.Key[0xC] = false
-			case glfw.KeyQ:
-				c8.Key[0x4] = false
-			case glfw.KeyW:
-				c8.Key[0x5] = false
-			case glfw.KeyE:
-				c8.Key[0x6] = false
-			case glfw.KeyR:
-				c8.Key[0xD] = false
-			case glfw.KeyA:
-				c8.Key[0x7] = false
-			case glfw.KeyS:
-				c8.Key[0x8] = false
-			case glfw.KeyD:
-				c8.Key[0x9] = false
-			case glfw.KeyF:
-				c8.Key[0xE] = false
-			case glfw.KeyZ:
-				c8.Key[0xA] = false
-			case glfw.KeyX:
-				c8.Key[0x0] = false
-			case glfw.KeyC:
-				c8.Key[0xB] = false
-			case glfw.KeyV:
-				c8.Key[0xF] = false
+		case glfw.KeyF10:
+			if c8.Stopped() {
+				if err := c8.Step(); err != nil {
+					log.Print(err)
+				}
+				screen.dirty = true
+				if pane {
+					printDisassembly(c8)
+				}
 			}
+		case glfw.KeyP:
+			if c8.Stopped() {
+				c8.Resume()
+			} else {
+				c8.Stop()
+				if pane {
+					printDisassembly(c8)
+				}
+			}
+		case glfw.KeyT:
+			if c8.Stopped() {
+				c8.TickTimerManual()
+				log.Printf("DT=%d ST=%d",
+					c8.DelayTimerValue(), c8.SoundTimerValue())
+			}
+		}
+	}
+}
+
+// Saves the program as it is in memory, including any changes it made to
+// itself, as a ROM in the working directory.
+func writeMemoryDump(c8 *chip8.Chip8) {
+	start := chip8.RomLoadAddr
+	rom := c8.DumpMemory(start, start+uint16(c8.RomSize()))
+	name := fmt.Sprintf("chip8-dump-%d.ch8", time.Now().Unix())
+	if err := os.WriteFile(name, rom, 0644); err != nil {
+		log.Printf("Error writing memory dump: %v", err)
+		return
+	}
+	log.Printf("Program written to %s", name)
+}
+
+// Prints the disassembly around PC, the current instruction marked. Lacking
+// text rendering in the window, the pane is the terminal.
+func printDisassembly(c8 *chip8.Chip8) {
+	fmt.Println(strings.Join(c8.DisassembleAround(8, 8), "\n"))
+}
+
+// Prints a bug report and saves it to a file in the working directory.
+func writeBugReport(c8 *chip8.Chip8) {
+	report := c8.BugReport()
+	fmt.Print(report)
+	name := fmt.Sprintf("chip8-bugreport-%d.txt", time.Now().Unix())
+	if err := os.WriteFile(name, []byte(report), 0644); err != nil {
+		log.Printf("Error writing bug report: %v", err)
+		return
+	}
+	log.Printf("Bug report written to %s", name)
+}
+
+// Parses a color given as hex RRGGBB.
+func parseColor(s string) (color.Color, error) {
+	rgb, err := strconv.ParseUint(s, 16, 24)
+	if err != nil || len(s) != 6 {
+		return nil, fmt.Errorf("Invalid color %q, expected RRGGBB", s)
+	}
+	return color.RGBA{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), 0xff}, nil
+}
+
+// Returns the components of a color as used by GL.
+func glColor(c color.Color) [3]float32 {
+	r, g, b, _ := c.RGBA()
+	return [3]float32{
+		float32(r) / 0xffff, float32(g) / 0xffff, float32(b) / 0xffff}
+}
+
+// Palettes selectable with -palette. The 4-color ones show XO-CHIP programs
+// drawing to both bitplanes.
+var palettes = map[string]string{
+	"default": "1a1a1a,d9d9d9,ff6600,662200",
+	"xochip":  "996600,ffcc00,ff6600,662200",
+	"amber":   "1a0d00,ffb000",
+	"green":   "0f380f,9bbc0f",
+}
+
+// Parses a palette given as the name of one in palettes or as a list of
+// colors.
+func parsePalette(s string) (chip8.Palette, error) {
+	if colors, ok := palettes[s]; ok {
+		s = colors
+	}
+	var p chip8.Palette
+	for _, hex := range strings.Split(s, ",") {
+		c, err := parseColor(hex)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, c)
+	}
+	if len(p) < 2 {
+		return nil, errors.New("Palette needs at least 2 colors")
+	}
+	return p, nil
+}
+
+// Cycles through the palettes selectable by name, see -palette, in order of
+// name. The palette is saved to the ROM settings on exit if changed.
+type palettePicker struct {
+	names   []string
+	current int // Index in names, -1 for a palette not in palettes
+	changed bool
+}
+
+func newPalettePicker(palette string) *palettePicker {
+	p := &palettePicker{current: -1}
+	for name := range palettes {
+		p.names = append(p.names, name)
+	}
+	sort.Strings(p.names)
+	for i, name := range p.names {
+		if name == palette {
+			p.current = i
+		}
+	}
+	return p
+}
+
+// Selects the next palette.
+func (p *palettePicker) next() {
+	p.current = (p.current + 1) % len(p.names)
+	p.changed = true
+}
+
+// Returns the name of the selected palette.
+func (p *palettePicker) name() string {
+	return p.names[p.current]
+}
+
+// The GL state for drawing the Chip-8 display.
+type screen struct {
+	vertex  []uint32 // Vertex indices to draw
+	color   int32    // Location of the color uniform
+	palette chip8.Palette
+	dirty   bool // Whether to draw even if the display didn't change
+	grid    bool // Whether to draw lines between the pixels
+	overlay overlay
+	// Scales the colors of the display, 1 unless fading in.
+	brightness float32
+}
+
+// Shows the blank display fading in from black over d, as a power-on
+// sequence.
+func fadeIn(window *glfw.Window, s *screen, d time.Duration) {
+	var blank [chip8.DisplayWidth][chip8.DisplayHeight]uint8
+	start := time.Now()
+	for t := time.Duration(0); t < d && !window.ShouldClose(); {
+		s.brightness = float32(t) / float32(d)
+		s.draw(&blank)
+		window.SwapBuffers()
+		glfw.PollEvents()
+		t = time.Since(start)
+	}
+	s.brightness = 1
+	s.dirty = true
+}
+
+// Returns the color c shaded by the brightness of the display.
+func (s *screen) shade(c [3]float32) [3]float32 {
+	for i := range c {
+		c[i] *= s.brightness
+	}
+	return c
+}
+
+// Reports whether to draw the grid, toggled with G or shown by the grid
+// overlay. The overlay leaves the toggle as it was.
+func (s *screen) showGrid() bool {
+	return s.grid || s.overlay == overlayGrid
+}
+
+// Draws the display background and the pixels that are on, in the color of
+// their value.
+func (s *screen) draw(gfx *[chip8.DisplayWidth][chip8.DisplayHeight]uint8) {
+	s.dirty = false
+	gl.Clear(gl.COLOR_BUFFER_BIT)
+	// The background quad comes first, followed by the pixel quads of each
+	// color in turn.
+	h := chip8.DisplayHeight + 1
+	bottomRight := uint32(chip8.DisplayWidth*h + chip8.DisplayHeight)
+	n := copy(s.vertex, []uint32{
+		0, chip8.DisplayHeight, uint32(chip8.DisplayWidth * h),
+		chip8.DisplayHeight, uint32(chip8.DisplayWidth * h), bottomRight,
+	})
+	ends := make([]int, len(s.palette))
+	ends[0] = n
+	for i := 1; i < len(s.palette); i++ {
+		n += fillVerticesToDraw(gfx, s.palette, uint8(i), s.vertex[n:])
+		ends[i] = n
+	}
+	showGrid := s.showGrid()
+	grid := 0
+	if showGrid {
+		grid = copy(s.vertex[n:], gridLines())
+	}
+	gl.BufferSubData(gl.ELEMENT_ARRAY_BUFFER, 0, (n+grid)*4, gl.Ptr(s.vertex))
+	start := 0
+	for i, end := range ends {
+		c := s.shade(glColor(s.palette[i]))
+		gl.Uniform3fv(s.color, 1, &c[0])
+		gl.DrawElements(gl.TRIANGLES, int32(end-start), gl.UNSIGNED_INT,
+			gl.PtrOffset(start*4))
+		start = end
+	}
+	if showGrid {
+		// A faint color between background and foreground.
+		bg, fg := glColor(s.palette[0]), glColor(s.palette[1])
+		var c [3]float32
+		for i := range c {
+			c[i] = bg[i] + (fg[i]-bg[i])/6
 		}
+		c = s.shade(c)
+		gl.Uniform3fv(s.color, 1, &c[0])
+		gl.DrawElements(gl.LINES, int32(grid), gl.UNSIGNED_INT,
+			gl.PtrOffset(n*4))
+	}
+}
+
+// Returns the vertex indices of the lines between the pixels of the display,
+// pairs of vertices at the ends of each line.
+func gridLines() []uint32 {
+	h := uint32(chip8.DisplayHeight + 1)
+	var vertex []uint32
+	for x := uint32(0); x <= chip8.DisplayWidth; x++ {
+		vertex = append(vertex, x*h, x*h+chip8.DisplayHeight)
 	}
+	for y := uint32(0); y <= chip8.DisplayHeight; y++ {
+		vertex = append(vertex, y, chip8.DisplayWidth*h+y)
+	}
+	return vertex
 }
 
-func fillVerticesToDraw(c8 *chip8.Chip8, vertex []uint32) int {
+// Fills vertex with the quads of the pixels with the given palette index.
+func fillVerticesToDraw(
+	gfx *[chip8.DisplayWidth][chip8.DisplayHeight]uint8, palette chip8.Palette,
+	index uint8, vertex []uint32) int {
 	h := chip8.DisplayHeight + 1
 	n := 0
-	for x := range c8.Gfx {
-		for y := range c8.Gfx[x] {
-			if c8.Gfx[x][y] == 1 {
+	for x := range gfx {
+		for y := range gfx[x] {
+			if palette.Index(gfx[x][y]) == index {
 				// Corners of quad
 				q1 := uint32(x*h + y)
 				q2 := uint32(x*h + y + 1)
@@ -194,19 +1282,39 @@ func fillVerticesToDraw(c8 *chip8.Chip8, vertex []uint32) int {
 	return n // Number of vertices
 }
 
-var (
-	vertexShaderGlsl = `#version 410 core
+const (
+	vertexShaderGlsl = `
 in vec2 pos;
 void main() {
 	gl_Position = vec4(pos, 0.0, 1.0);
-}` + "\x00"
-	fragmentShaderGlsl = `#version 410 core
+}`
+	fragmentShaderGlsl = `
+uniform vec3 fg;
 out vec4 color;
 void main() {
-	color = vec4(0.85, 0.85, 0.85, 1.0);
-}` + "\x00"
+	color = vec4(fg, 1.0);
+}`
 )
 
+// The GLSL versions the shaders are compiled with, tried in order until one
+// works since some drivers reject the newer ones.
+var shaderVersions = [...]string{"410 core", "330 core", "150"}
+
+// Returns the program made by newProgram with the first of versions it
+// succeeds with.
+func firstProgram(versions []string,
+	newProgram func(version string) (uint32, error)) (uint32, error) {
+	for _, version := range versions {
+		program, err := newProgram(version)
+		if err == nil {
+			log.Printf("Using GLSL version %s", version)
+			return program, nil
+		}
+		log.Printf("GLSL version %s failed: %v", version, err)
+	}
+	return 0, errors.New("No supported GLSL version")
+}
+
 func checkShaderError(shader uint32) error {
 	var status int32
 	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status)
@@ -220,7 +1328,57 @@ func checkShaderError(shader uint32) error {
 	return nil
 }
 
-func glSetup() (vertex []uint32, err error) {
+// Compiles a shader of the given type from source, prefixed with a #version
+// directive.
+func compileShader(kind uint32, version, source string) (uint32, error) {
+	shader := gl.CreateShader(kind)
+	cstr, free := gl.Strs("#version " + version + "\n" + source + "\x00")
+	defer free()
+	gl.ShaderSource(shader, 1, cstr, nil)
+	gl.CompileShader(shader)
+	if err := checkShaderError(shader); err != nil {
+		gl.DeleteShader(shader)
+		return 0, err
+	}
+	return shader, nil
+}
+
+// Compiles and links the shaders with the given GLSL version.
+func newProgram(version string) (uint32, error) {
+	vertexShader, err := compileShader(
+		gl.VERTEX_SHADER, version, vertexShaderGlsl)
+	if err != nil {
+		return 0, fmt.Errorf("Vertex shader error: %v", err)
+	}
+	defer gl.DeleteShader(vertexShader)
+
+	fragmentShader, err := compileShader(
+		gl.FRAGMENT_SHADER, version, fragmentShaderGlsl)
+	if err != nil {
+		return 0, fmt.Errorf("Fragment shader error: %v", err)
+	}
+	defer gl.DeleteShader(fragmentShader)
+
+	program := gl.CreateProgram()
+	gl.AttachShader(program, vertexShader)
+	gl.AttachShader(program, fragmentShader)
+	gl.BindFragDataLocation(program, 0, gl.Str("color\x00"))
+	gl.LinkProgram(program)
+
+	var status int32
+	gl.GetProgramiv(program, gl.LINK_STATUS, &status)
+	if status == gl.FALSE {
+		var length int32
+		gl.GetProgramiv(program, gl.INFO_LOG_LENGTH, &length)
+		log := strings.Repeat("\x00", 1+int(length))
+		gl.GetProgramInfoLog(program, length, nil, gl.Str(log))
+		gl.DeleteProgram(program)
+		return 0, fmt.Errorf("Program link error: %s", log)
+	}
+	return program, nil
+}
+
+func glSetup(bezel int, palette chip8.Palette) (*screen, error) {
 	if err := gl.Init(); err != nil {
 		return nil, err
 	}
@@ -250,16 +1408,8 @@ func glSetup() (vertex []uint32, err error) {
 	// ...   | . . . . . . . . . . |
 	// 32    | . . . . . . . . . . |
 	//       +---------------------+
-	w, h := chip8.DisplayWidth+1, chip8.DisplayHeight+1
-	ncoords := w * h * 2 // 2 coordinates for each vertex
-	buf := make([]float32, ncoords, ncoords)
-	for x := 0; x < w; x++ {
-		for y := 0; y < h; y++ {
-			i := 2 * (x*h + y)
-			buf[i] = -1 + float32(x)/float32(chip8.DisplayWidth/2)
-			buf[i+1] = 1 - float32(y)/float32(chip8.DisplayHeight/2)
-		}
-	}
+	buf := gridVertices(bezel)
+	ncoords := len(buf)
 
 	var vbo uint32
 	gl.GenBuffers(1, &vbo)
@@ -267,7 +1417,7 @@ func glSetup() (vertex []uint32, err error) {
 	gl.BufferData(gl.ARRAY_BUFFER, len(buf)*4, gl.Ptr(buf), gl.STATIC_DRAW)
 
 	// 65*33 quads, each quad needs 6 vertices
-	vertex = make([]uint32, ncoords*3, ncoords*3)
+	vertex := make([]uint32, ncoords*3, ncoords*3)
 
 	var ebo uint32
 	gl.GenBuffers(1, &ebo)
@@ -275,43 +1425,12 @@ func glSetup() (vertex []uint32, err error) {
 	gl.BufferData(
 		gl.ELEMENT_ARRAY_BUFFER, len(vertex)*4, gl.Ptr(vertex), gl.DYNAMIC_DRAW)
 
-	vertexShader := gl.CreateShader(gl.VERTEX_SHADER)
-	vertexShaderCStr := gl.Str(vertexShaderGlsl)
-	gl.ShaderSource(vertexShader, 1, &vertexShaderCStr, nil)
-	gl.CompileShader(vertexShader)
-	defer gl.DeleteShader(vertexShader)
-
-	if err := checkShaderError(vertexShader); err != nil {
-		return nil, fmt.Errorf("Vertex shader error: %v", err)
-	}
-
-	fragmentShader := gl.CreateShader(gl.FRAGMENT_SHADER)
-	fragmentShaderCStr := gl.Str(fragmentShaderGlsl)
-	gl.ShaderSource(fragmentShader, 1, &fragmentShaderCStr, nil)
-	gl.CompileShader(fragmentShader)
-	defer gl.DeleteShader(fragmentShader)
-
-	if err := checkShaderError(fragmentShader); err != nil {
-		return nil, fmt.Errorf("Fragment shader error: %v", err)
+	program, err := firstProgram(shaderVersions[:], newProgram)
+	if err != nil {
+		return nil, err
 	}
-
-	program := gl.CreateProgram()
-	gl.AttachShader(program, vertexShader)
-	gl.AttachShader(program, fragmentShader)
-	gl.BindFragDataLocation(program, 0, gl.Str("color\x00"))
-	gl.LinkProgram(program)
 	gl.UseProgram(program)
 
-	var status int32
-	gl.GetProgramiv(program, gl.LINK_STATUS, &status)
-	if status == gl.FALSE {
-		var length int32
-		gl.GetProgramiv(program, gl.INFO_LOG_LENGTH, &length)
-		log := strings.Repeat("\x00", 1+int(length))
-		gl.GetProgramInfoLog(program, length, nil, gl.Str(log))
-		return nil, fmt.Errorf("Program link error: %s", log)
-	}
-
 	gl.EnableVertexAttribArray(0)
 	gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
 	gl.VertexAttribPointer(0, 2, gl.FLOAT, false, 0, gl.PtrOffset(0))
@@ -322,5 +1441,24 @@ func glSetup() (vertex []uint32, err error) {
 		return nil, fmt.Errorf("GL error: 0x%x", err)
 	}
 
-	return vertex, nil
+	fg := gl.GetUniformLocation(program, gl.Str("fg\x00"))
+	return &screen{vertex: vertex, color: fg, palette: palette,
+		brightness: 1}, nil
+}
+
+// Returns the coordinates of the vertices of the pixel grid, see glSetup. The
+// grid is inset by a border of bezel pixels on each side.
+func gridVertices(bezel int) []float32 {
+	w, h := chip8.DisplayWidth+1, chip8.DisplayHeight+1
+	fullWidth := float32(chip8.DisplayWidth + 2*bezel)
+	fullHeight := float32(chip8.DisplayHeight + 2*bezel)
+	buf := make([]float32, w*h*2) // 2 coordinates for each vertex
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			i := 2 * (x*h + y)
+			buf[i] = -1 + float32(x+bezel)/(fullWidth/2)
+			buf[i+1] = 1 - float32(y+bezel)/(fullHeight/2)
+		}
+	}
+	return buf
 }
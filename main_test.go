@@ -0,0 +1,648 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-gl/glfw/v3.2/glfw"
+
+	"chip8-go/chip8"
+)
+
+func TestBell(t *testing.T) {
+	var out strings.Builder
+	b := bell{w: &out}
+	tests := []struct {
+		active bool
+		rings  int // Total rings after the update
+	}{
+		{false, 0},
+		{true, 1},  // Activated
+		{true, 1},  // Still active
+		{false, 1}, // Stopped
+		{false, 1},
+		{true, 2}, // Activated again
+	}
+	for i, test := range tests {
+		b.update(test.active)
+		if got := strings.Count(out.String(), "\a"); got != test.rings {
+			t.Errorf("Update %d to %v: %d rings, want %d",
+				i, test.active, got, test.rings)
+		}
+	}
+}
+
+func TestSpeedCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		speeds   []int
+		warnings int
+	}{
+		{"unset", []int{0}, 0},
+		{"typical", []int{600, 100, 5000}, 0},
+		{"too slow", []int{50}, 1},
+		{"too fast", []int{100000}, 1},
+		{"repeated", []int{50, 50, 100000}, 1},
+		{"settled out of range", []int{600, 10000}, 1},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		s := speedCheck{log: log.New(&out, "", 0)}
+		for _, ips := range test.speeds {
+			s.check(ips)
+		}
+		if got := strings.Count(out.String(), "Warning"); got != test.warnings {
+			t.Errorf("%s: %d warnings, want %d:\n%s",
+				test.name, got, test.warnings, out.String())
+		}
+	}
+}
+
+func TestPresenterCoalesce(t *testing.T) {
+	rom := []uint8{
+		0xd0, 0x15, // DRW V0, V1, 5
+		0x62, 0x00, // 0x202: LD V2, 0
+		0xd0, 0x15, // DRW V0, V1, 5 (erase)
+		0xd0, 0x15, // DRW V0, V1, 5 (redraw)
+		0x12, 0x02, // JP 0x202
+	}
+	const steps = 40
+	const perFrame = 4 // Each frame runs JP, LD, erase, redraw
+	tests := []struct {
+		coalesce bool
+		shows    int
+		erased   bool // Whether the erased display is shown
+	}{
+		{false, 1 + 2*steps/perFrame, true},
+		{true, steps/perFrame - 1, false},
+	}
+	for _, test := range tests {
+		c8 := chip8.New()
+		if err := c8.LoadRomData(rom); err != nil {
+			t.Fatal(err)
+		}
+		blank := c8.FrameHash()
+		p := presenter{coalesce: test.coalesce}
+		now := time.Now()
+		shows, erased := 0, false
+		for i := 0; i < steps; i++ {
+			if err := c8.Step(); err != nil {
+				t.Fatal(err)
+			}
+			if p.update(c8.Draw, uint64(i/perFrame), now) {
+				shows++
+				erased = erased || c8.FrameHash() == blank
+			}
+		}
+		if shows != test.shows || erased != test.erased {
+			t.Errorf("coalesce %v: %d shows, erased display shown %v, "+
+				"want %d, %v", test.coalesce, shows, erased, test.shows,
+				test.erased)
+		}
+	}
+}
+
+func TestKeypadEvent(t *testing.T) {
+	c8 := chip8.New()
+	tests := []struct {
+		key     glfw.Key
+		action  glfw.Action
+		keypad  bool
+		pressed bool // State of keypad key 4 after the event
+	}{
+		{glfw.KeyQ, glfw.Repeat, true, false},
+		{glfw.KeyQ, glfw.Press, true, true},
+		{glfw.KeyQ, glfw.Repeat, true, true},
+		{glfw.KeyQ, glfw.Repeat, true, true},
+		{glfw.KeyQ, glfw.Release, true, false},
+		{glfw.KeyQ, glfw.Repeat, true, false},
+		{glfw.KeyP, glfw.Press, false, false},
+	}
+	for i, test := range tests {
+		if got := keypadEvent(c8, test.key, test.action); got != test.keypad {
+			t.Errorf("event %d: keypad key = %v, want %v", i, got, test.keypad)
+		}
+		if got := c8.KeyPressed(4); got != test.pressed {
+			t.Errorf("event %d: key 4 pressed = %v, want %v",
+				i, got, test.pressed)
+		}
+	}
+}
+
+// A recording finalized on exit.
+type fakeRecorder struct {
+	finalized int
+	err       error
+}
+
+func (r *fakeRecorder) Finalize() error {
+	r.finalized++
+	return r.err
+}
+
+func TestShutdown(t *testing.T) {
+	var out strings.Builder
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	var order []string
+	recorders := []struct {
+		name string
+		rec  *fakeRecorder
+	}{
+		{"gif", &fakeRecorder{}},
+		{"input", &fakeRecorder{err: errors.New("Disk full")}},
+		{"flags", &fakeRecorder{}},
+	}
+	var s shutdown
+	for _, r := range recorders {
+		r := r
+		s.add(r.name, func() error {
+			order = append(order, r.name)
+			return r.rec.Finalize()
+		})
+	}
+	s.run()
+	for _, r := range recorders {
+		if r.rec.finalized != 1 {
+			t.Errorf("%s finalized %d times, want once", r.name, r.rec.finalized)
+		}
+	}
+	if want := "[flags input gif]"; fmt.Sprint(order) != want {
+		t.Errorf("finalized in order %v, want %s", order, want)
+	}
+	if want := "Error finalizing input: Disk full"; !strings.Contains(
+		out.String(), want) {
+		t.Errorf("log %q doesn't contain %q", out.String(), want)
+	}
+}
+
+func TestFirstProgram(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	tests := []struct {
+		name      string
+		supported map[string]uint32 // Program for each version that works
+		want      uint32
+		tried     []string
+	}{
+		{"newest", map[string]uint32{"410 core": 1, "330 core": 2}, 1,
+			[]string{"410 core"}},
+		{"fallback", map[string]uint32{"330 core": 2, "150": 3}, 2,
+			[]string{"410 core", "330 core"}},
+		{"last", map[string]uint32{"150": 3}, 3,
+			[]string{"410 core", "330 core", "150"}},
+		{"none", nil, 0, []string{"410 core", "330 core", "150"}},
+	}
+	for _, test := range tests {
+		var tried []string
+		program, err := firstProgram(shaderVersions[:],
+			func(version string) (uint32, error) {
+				tried = append(tried, version)
+				if p, ok := test.supported[version]; ok {
+					return p, nil
+				}
+				return 0, errors.New("Unsupported version")
+			})
+		if program != test.want || (err != nil) != (test.want == 0) {
+			t.Errorf("%s: program %d, error %v, want %d", test.name, program,
+				err, test.want)
+		}
+		if fmt.Sprint(tried) != fmt.Sprint(test.tried) {
+			t.Errorf("%s: tried %q, want %q", test.name, tried, test.tried)
+		}
+	}
+}
+
+func TestRunFrames(t *testing.T) {
+	c8 := chip8.New()
+	err := c8.LoadRomData([]uint8{
+		0x60, 0x01, // LD V0, 1
+		0xd0, 0x05, // DRW V0, V0, 5
+		0x60, 0x09, // LD V0, 9
+		0xd0, 0x05, // DRW V0, V0, 5
+		0x00, 0xe0, // CLS
+		0x12, 0x0a, // JP 0x20A
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := chip8.NewRunner(c8, chip8.FrameRate) // An instruction a frame
+	palette, err := parsePalette("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	frames, err := runFrames(runner, palette, 10*time.Second/chip8.FrameRate,
+		dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != 10 {
+		t.Errorf("ran %d frames, want 10", frames)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := "[000001.png 000003.png 000004.png]"
+	if fmt.Sprint(names) != want {
+		t.Errorf("frame files %v, want %s", names, want)
+	}
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	c8 := chip8.New()
+	err := c8.LoadRomData([]uint8{
+		0x60, 0x05, // LD V0, 5
+		0xff, 0xff, // Unknown
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := supervisor{max: 3, window: time.Minute}
+	start := time.Now()
+	tests := []struct {
+		at      time.Duration // Time of the crash
+		restart bool
+	}{
+		{0, true},
+		{10 * time.Second, true},
+		{20 * time.Second, true},
+		{30 * time.Second, false}, // Capped
+		{50 * time.Second, false},
+		{61 * time.Second, true}, // The first restart is over a minute ago
+		{62 * time.Second, false},
+	}
+	for _, test := range tests {
+		if _, err = c8.StepN(2); err == nil {
+			t.Fatal("no error from the unknown instruction")
+		}
+		got := s.restart(c8, err, start.Add(test.at))
+		if (got == nil) != test.restart {
+			t.Errorf("crash at %v: restart error %v, want restart %v",
+				test.at, got, test.restart)
+		}
+		if got != nil && got != err {
+			t.Errorf("crash at %v: error %v, want %v", test.at, got, err)
+		}
+		if pc := c8.SaveContext().PC; (pc == 0x200) != test.restart {
+			t.Errorf("crash at %v: PC = 0x%03x, want reset %v", test.at, pc,
+				test.restart)
+		}
+		c8.Reset()
+	}
+}
+
+func TestPalettePicker(t *testing.T) {
+	tests := []struct {
+		start string
+		want  []string // Names after each call to next
+	}{
+		{"amber", []string{"default", "green", "xochip", "amber", "default"}},
+		{"xochip", []string{"amber", "default"}},
+		{"ff0000,00ff00", []string{"amber", "default"}}, // Not a preset
+	}
+	for _, test := range tests {
+		p := newPalettePicker(test.start)
+		if p.changed {
+			t.Errorf("%s: changed before next", test.start)
+		}
+		var got []string
+		for range test.want {
+			p.next()
+			got = append(got, p.name())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: cycled through %v, want %v", test.start, got,
+				test.want)
+		}
+		if !p.changed {
+			t.Errorf("%s: not changed after next", test.start)
+		}
+	}
+}
+
+func TestInputWaiter(t *testing.T) {
+	tests := []struct {
+		mode    string
+		closeAt int // Wait closing the window, 0 for none
+		waits   int
+		v3      uint8 // The key read by Fx0A
+		stopped bool
+	}{
+		{"poll", 0, 0, 0, false},
+		{"wait", 0, 4, 7, false}, // Key 7 pressed and released
+		{"wait", 2, 2, 0, true},
+	}
+	for _, test := range tests {
+		c8 := chip8.New()
+		if err := c8.LoadRomData([]uint8{0xf3, 0x0a}); err != nil { // LD V3, K
+			t.Fatal(err)
+		}
+		waits := 0
+		wait := func() {
+			waits++
+			// A key counts once released.
+			switch waits {
+			case 2:
+				c8.SetKey(7, true)
+			case 4:
+				c8.SetKey(7, false)
+			}
+		}
+		closing := func() bool { return waits == test.closeAt }
+		waitForInput := inputWaiter(test.mode, c8, wait, closing)
+		if (waitForInput == nil) != (test.mode == "poll") {
+			t.Fatalf("%s: waitForInput = nil is %v", test.mode,
+				waitForInput == nil)
+		}
+		if err := c8.Cycle(waitForInput); err != nil {
+			t.Fatal(err)
+		}
+		ctx := c8.SaveContext()
+		if waits != test.waits || ctx.V[3] != test.v3 ||
+			c8.Stopped() != test.stopped {
+			t.Errorf("%s: %d waits, V3 = %d, stopped %v, want %d, %d, %v",
+				test.mode, waits, ctx.V[3], c8.Stopped(),
+				test.waits, test.v3, test.stopped)
+		}
+	}
+}
+
+func TestInputProfile(t *testing.T) {
+	saved, savedProfile := keymap, *inputProfile
+	defer func() { keymap, *inputProfile = saved, savedProfile }()
+	tests := []struct {
+		profile string
+		keys    map[glfw.Key]uint8 // Mapped keys, 0x10 for none
+	}{
+		{"standard", map[glfw.Key]uint8{
+			glfw.Key1: 0x1, glfw.KeyV: 0xf, glfw.KeyKP7: 0x10,
+		}},
+		{"hp48", map[glfw.Key]uint8{
+			// The HP48 keys in the layout of the keypad.
+			glfw.KeyKP7: 0x1, glfw.KeyKP8: 0x2, glfw.KeyKP9: 0x3, glfw.KeyKPDivide: 0xc,
+			glfw.KeyKP4: 0x4, glfw.KeyKP5: 0x5, glfw.KeyKP6: 0x6, glfw.KeyKPMultiply: 0xd,
+			glfw.KeyKP1: 0x7, glfw.KeyKP2: 0x8, glfw.KeyKP3: 0x9, glfw.KeyKPSubtract: 0xe,
+			glfw.KeyKP0: 0xa, glfw.KeyKPDecimal: 0x0, glfw.KeyKPEnter: 0xb, glfw.KeyKPAdd: 0xf,
+			// Still mapped on top of the standard keys.
+			glfw.Key1: 0x1, glfw.KeyV: 0xf,
+		}},
+	}
+	for _, test := range tests {
+		keymap = make(map[glfw.Key]uint8)
+		for key, k := range saved {
+			keymap[key] = k
+		}
+		*inputProfile = test.profile
+		if err := setInputProfile(); err != nil {
+			t.Fatal(err)
+		}
+		for key, want := range test.keys {
+			got, ok := keymap[key]
+			if !ok {
+				got = 0x10
+			}
+			if got != want {
+				t.Errorf("%s: key %d maps to %x, want %x", test.profile, key,
+					got, want)
+			}
+		}
+	}
+	*inputProfile = "hp49"
+	if err := setInputProfile(); err == nil {
+		t.Error("no error for an unknown profile")
+	}
+}
+
+func TestGridGeometry(t *testing.T) {
+	const w, h = chip8.DisplayWidth, chip8.DisplayHeight
+	near := func(a, b float32) bool { return math.Abs(float64(a-b)) < 1e-6 }
+	for _, bezel := range []int{0, 2} {
+		buf := gridVertices(bezel)
+		if len(buf) != (w+1)*(h+1)*2 {
+			t.Fatalf("bezel %d: %d coordinates, want %d", bezel, len(buf),
+				(w+1)*(h+1)*2)
+		}
+		// Each logical pixel is a cell of the same size, inset by the bezel.
+		cellW := 2 / float32(w+2*bezel)
+		cellH := 2 / float32(h+2*bezel)
+		vertex := func(i uint32) (float32, float32) {
+			return buf[2*i], buf[2*i+1]
+		}
+		for x := 0; x <= w; x++ {
+			for y := 0; y <= h; y++ {
+				gx, gy := vertex(uint32(x*(h+1) + y))
+				wx := -1 + float32(x+bezel)*cellW
+				wy := 1 - float32(y+bezel)*cellH
+				if !near(gx, wx) || !near(gy, wy) {
+					t.Fatalf("bezel %d: vertex (%d, %d) at (%v, %v), "+
+						"want (%v, %v)", bezel, x, y, gx, gy, wx, wy)
+				}
+			}
+		}
+
+		// A line between each column and each row of pixels, across the
+		// display.
+		lines := gridLines()
+		if len(lines) != 2*(w+1+h+1) {
+			t.Fatalf("%d line ends, want %d", len(lines), 2*(w+1+h+1))
+		}
+		top, bottom := 1-float32(bezel)*cellH, 1-float32(h+bezel)*cellH
+		left, right := -1+float32(bezel)*cellW, -1+float32(w+bezel)*cellW
+		for i := 0; i < len(lines); i += 2 {
+			x1, y1 := vertex(lines[i])
+			x2, y2 := vertex(lines[i+1])
+			vertical := i/2 <= w
+			var ok bool
+			if vertical {
+				n := i / 2
+				ok = near(x1, left+float32(n)*cellW) && near(x1, x2) &&
+					near(y1, top) && near(y2, bottom)
+			} else {
+				n := i/2 - (w + 1)
+				ok = near(y1, top-float32(n)*cellH) && near(y1, y2) &&
+					near(x1, left) && near(x2, right)
+			}
+			if !ok {
+				t.Errorf("bezel %d: line %d from (%v, %v) to (%v, %v)",
+					bezel, i/2, x1, y1, x2, y2)
+			}
+		}
+	}
+}
+
+func TestStartEmulation(t *testing.T) {
+	saved := *speed
+	defer func() { *speed = saved }()
+	*speed = chip8.DefaultSpeed
+	c8 := chip8.New()
+	if err := c8.LoadRomData([]uint8{0x12, 0x00}); err != nil { // JP 0x200
+		t.Fatal(err)
+	}
+	cycles := 0
+	c8.AfterCycle = func(*chip8.Chip8, uint16) { cycles++ }
+	hooks := 0
+	runner := startEmulation(c8, func() {
+		hooks++
+		if cycles != 0 {
+			t.Errorf("%d cycles run before the power-on sequence", cycles)
+		}
+		time.Sleep(10 * time.Second / chip8.FrameRate)
+	})
+	if runner == nil {
+		t.Fatal("no runner")
+	}
+	// The frames of the sequence aren't caught up afterwards.
+	if n, err := runner.Advance(nil); err != nil || n != 0 {
+		t.Errorf("Advance() = %d, %v right after starting, want 0", n, err)
+	}
+	time.Sleep(2 * time.Second / chip8.FrameRate)
+	if n, err := runner.Advance(nil); err != nil || n == 0 || cycles == 0 {
+		t.Errorf("Advance() = %d, %v with %d cycles after 2 frames", n, err,
+			cycles)
+	}
+	if hooks != 1 {
+		t.Errorf("power-on sequence run %d times, want once", hooks)
+	}
+}
+
+func TestPresenterInterval(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		shows    int // Of a burst of a draw every ms for 100 ms
+	}{
+		{time.Second / 60, 6}, // At 0, 17, 34, 51, 67 and 84 ms
+		{10 * time.Millisecond, 10},
+		{50 * time.Millisecond, 2},
+	}
+	start := time.Now()
+	for _, test := range tests {
+		p := presenter{interval: test.interval}
+		var shown []time.Time
+		for i := 0; i < 100; i++ {
+			now := start.Add(time.Duration(i) * time.Millisecond)
+			if p.update(true, 0, now) {
+				shown = append(shown, now)
+			}
+		}
+		if len(shown) != test.shows {
+			t.Errorf("interval %v: %d shows, want %d", test.interval,
+				len(shown), test.shows)
+		}
+		for i := 1; i < len(shown); i++ {
+			if d := shown[i].Sub(shown[i-1]); d < test.interval {
+				t.Errorf("interval %v: shown again after %v", test.interval, d)
+			}
+		}
+		// The last draws are shown once due, without further draws.
+		due := shown[len(shown)-1].Add(test.interval)
+		if p.update(false, 0, due.Add(-time.Microsecond)) {
+			t.Errorf("interval %v: shown before due", test.interval)
+		}
+		if !p.update(false, 0, due) {
+			t.Errorf("interval %v: last draws not shown", test.interval)
+		}
+		if p.update(false, 0, due.Add(test.interval)) {
+			t.Errorf("interval %v: shown without changes", test.interval)
+		}
+	}
+}
+
+func TestPresenterCap(t *testing.T) {
+	tests := []struct {
+		cap     int
+		draws   int // In each frame
+		shows   int // Of the first frame
+		dropped uint64
+	}{
+		{0, 3, 3, 0}, // No limit
+		{1, 3, 1, 2},
+		{2, 3, 2, 1},
+		{1, 1, 1, 0},
+	}
+	now := time.Now()
+	for _, test := range tests {
+		p := presenter{cap: test.cap}
+		shows := 0
+		for i := 0; i < test.draws; i++ {
+			if p.update(true, 1, now) {
+				shows++
+			}
+		}
+		if shows != test.shows || p.dropped != test.dropped {
+			t.Errorf("cap %d, %d draws: %d shows, %d dropped, want %d, %d",
+				test.cap, test.draws, shows, p.dropped, test.shows,
+				test.dropped)
+		}
+		// The dropped draws are shown with the next frame.
+		if dropped := test.dropped > 0; p.update(false, 2, now) != dropped {
+			t.Errorf("cap %d, %d draws: next frame shown %v, want %v",
+				test.cap, test.draws, !dropped, dropped)
+		}
+	}
+}
+
+func TestOverlays(t *testing.T) {
+	for _, grid := range []bool{false, true} {
+		s := &screen{grid: grid}
+		var got []string
+		for i := 0; i < int(numOverlays); i++ {
+			s.overlay = s.overlay.next()
+			got = append(got, s.overlay.String())
+			if want := grid || s.overlay == overlayGrid; s.showGrid() != want {
+				t.Errorf("grid %v, overlay %s: grid shown %v, want %v", grid,
+					s.overlay, s.showGrid(), want)
+			}
+		}
+		want := "[registers keypad memory grid font off]"
+		if fmt.Sprint(got) != want {
+			t.Errorf("cycled through %v, want %s", got, want)
+		}
+		if s.overlay != overlayOff || s.grid != grid {
+			t.Errorf("overlay %s, grid %v after a cycle, want off, %v",
+				s.overlay, s.grid, grid)
+		}
+	}
+
+	c8 := chip8.New()
+	err := c8.LoadRomData([]byte{
+		0x60, 0x2a, // LD V0, 0x2a
+		0xa1, 0x23, // LD I, 0x123
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c8.StepN(2); err != nil {
+		t.Fatal(err)
+	}
+	c8.SetKey(0x3, true)
+	c8.SetKey(0xa, true)
+	tests := []struct {
+		overlay overlay
+		want    string
+	}{
+		{overlayOff, ""},
+		{overlayRegisters, " PC=204 I=123 V=2a" + strings.Repeat(" 00", 15)},
+		{overlayKeypad, " Keys: 3 A"},
+		{overlayGrid, ""},
+	}
+	for _, test := range tests {
+		if got := test.overlay.text(c8); got != test.want {
+			t.Errorf("%s: text %q, want %q", test.overlay, got, test.want)
+		}
+	}
+}
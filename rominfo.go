@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"chip8-go/chip8"
+)
+
+// Settings for a ROM read from a JSON file next to it, e.g. game.json for
+// game.ch8, letting ROM collections ship their preferred settings.
+type romInfo struct {
+	Title      string `json:"title,omitempty"`
+	Author     string `json:"author,omitempty"`
+	Profile    string `json:"profile,omitempty"`
+	Speed      int    `json:"speed,omitempty"`
+	Palette    string `json:"palette,omitempty"`    // As for -palette
+	Background string `json:"background,omitempty"` // Color as hex RRGGBB
+	Foreground string `json:"foreground,omitempty"`
+	path       string
+}
+
+// Reads the settings for the ROM at romPath. A missing file is not an error.
+func readRomInfo(romPath string) (*romInfo, error) {
+	path := strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".json"
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &romInfo{path: path}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	info := romInfo{path: path}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("Error reading %s: %v", path, err)
+	}
+	return &info, nil
+}
+
+// Writes the settings back to the file they were read from.
+func (info *romInfo) save() error {
+	data, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(info.path, append(data, '\n'), 0644)
+}
+
+// Saves the palette chosen with picker in place of the colors, if one was.
+func (info *romInfo) savePalette(picker *palettePicker) error {
+	if !picker.changed {
+		return nil
+	}
+	info.Palette, info.Background, info.Foreground = picker.name(), "", ""
+	return info.save()
+}
+
+// Returns the flags set on the command line.
+func setFlags() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+// Applies the settings to the flags, except for those set on the command
+// line, as given by setFlags.
+func (info *romInfo) apply(set map[string]bool) {
+	if info.Profile != "" && !set["profile"] {
+		*profile = info.Profile
+	}
+	if info.Speed != 0 && !set["speed"] {
+		*speed = info.Speed
+	}
+	if info.Palette != "" && !set["palette"] {
+		*paletteName = info.Palette
+	}
+}
+
+// Applies the colors to the palette, unless it was set on the command line.
+func (info *romInfo) applyColors(
+	palette chip8.Palette, set map[string]bool) error {
+	if set["palette"] {
+		return nil
+	}
+	for i, hex := range []string{info.Background, info.Foreground} {
+		if hex == "" {
+			continue
+		}
+		c, err := parseColor(hex)
+		if err != nil {
+			return err
+		}
+		palette[i] = c
+	}
+	return nil
+}
+
+// Returns the window title for the ROM.
+func (info *romInfo) title() string {
+	switch {
+	case info.Title != "" && info.Author != "":
+		return fmt.Sprintf("Chip-8 - %s by %s", info.Title, info.Author)
+	case info.Title != "":
+		return "Chip-8 - " + info.Title
+	}
+	return "Chip-8"
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRomInfo(t *testing.T) {
+	dir := t.TempDir()
+	romPath := filepath.Join(dir, "game.ch8")
+	sidecar := `{"title": "Pong", "author": "Paul Vervalin", "profile": "vip",
+		"speed": 900, "palette": "amber", "background": "102030"}`
+	err := os.WriteFile(filepath.Join(dir, "game.json"), []byte(sidecar), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedProfile, savedSpeed, savedPalette := *profile, *speed, *paletteName
+	defer func() {
+		*profile, *speed, *paletteName = savedProfile, savedSpeed, savedPalette
+	}()
+	tests := []struct {
+		name    string
+		set     map[string]bool // Flags set on the command line
+		profile string
+		speed   int
+		palette string
+		bg      color.Color
+	}{
+		{"applied", nil, "vip", 900, "amber", color.RGBA{0x10, 0x20, 0x30, 0xff}},
+		{"speed flag", map[string]bool{"speed": true}, "vip", 600, "amber",
+			color.RGBA{0x10, 0x20, 0x30, 0xff}},
+		{"palette flag", map[string]bool{"palette": true}, "vip", 900, "green",
+			color.RGBA{0x0f, 0x38, 0x0f, 0xff}},
+	}
+	for _, test := range tests {
+		*profile, *speed, *paletteName = "", 600, "green"
+		info, err := readRomInfo(romPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info.apply(test.set)
+		palette, err := parsePalette(*paletteName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := info.applyColors(palette, test.set); err != nil {
+			t.Fatal(err)
+		}
+		if *profile != test.profile || *speed != test.speed ||
+			*paletteName != test.palette {
+			t.Errorf("%s: profile %q, speed %d, palette %q, want %q, %d, %q",
+				test.name, *profile, *speed, *paletteName,
+				test.profile, test.speed, test.palette)
+		}
+		if palette[0] != test.bg {
+			t.Errorf("%s: background %v, want %v", test.name, palette[0], test.bg)
+		}
+		if want := "Chip-8 - Pong by Paul Vervalin"; info.title() != want {
+			t.Errorf("%s: title %q, want %q", test.name, info.title(), want)
+		}
+	}
+}
+
+func TestRomInfoMissing(t *testing.T) {
+	info, err := readRomInfo(filepath.Join(t.TempDir(), "game.ch8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.title() != "Chip-8" {
+		t.Errorf("title %q without a sidecar, want Chip-8", info.title())
+	}
+}
+
+func TestSavePalette(t *testing.T) {
+	romPath := filepath.Join(t.TempDir(), "game.ch8")
+	info, err := readRomInfo(romPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Title, info.Background = "Pong", "102030"
+	picker := newPalettePicker("amber")
+	if err := info.savePalette(picker); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(info.path); err == nil {
+		t.Error("saved without a palette chosen")
+	}
+	picker.next()
+	if err := info.savePalette(picker); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := readRomInfo(romPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Palette != "default" || saved.Background != "" ||
+		saved.Title != "Pong" {
+		t.Errorf("saved %+v, want the default palette and title Pong", saved)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+// A program showing "CHIP-8", run when no ROM is given with -splash.
+var splashRom = []uint8{
+	0x60, 0x11, // 0x200: LD V0, 17
+	0x61, 0x0d, // 0x202: LD V1, 13
+	0x62, 0x0c, // 0x204: LD V2, 0x0c
+	0xf2, 0x29, // 0x206: LD F, V2
+	0xd0, 0x15, // 0x208: DRW V0, V1, 5
+	0x70, 0x05, // 0x20a: ADD V0, 5
+	0xa2, 0x2c, // 0x20c: LD I, 0x22c
+	0xd0, 0x15, // 0x20e: DRW V0, V1, 5
+	0x70, 0x05, // 0x210: ADD V0, 5
+	0xa2, 0x31, // 0x212: LD I, 0x231
+	0xd0, 0x15, // 0x214: DRW V0, V1, 5
+	0x70, 0x05, // 0x216: ADD V0, 5
+	0xa2, 0x36, // 0x218: LD I, 0x236
+	0xd0, 0x15, // 0x21a: DRW V0, V1, 5
+	0x70, 0x05, // 0x21c: ADD V0, 5
+	0xa2, 0x3b, // 0x21e: LD I, 0x23b
+	0xd0, 0x15, // 0x220: DRW V0, V1, 5
+	0x70, 0x05, // 0x222: ADD V0, 5
+	0x62, 0x08, // 0x224: LD V2, 0x08
+	0xf2, 0x29, // 0x226: LD F, V2
+	0xd0, 0x15, // 0x228: DRW V0, V1, 5
+	0x12, 0x2a, // 0x22a: JP 0x22a
+	0x90, 0x90, 0xf0, 0x90, 0x90, // 0x22c: H
+	0xe0, 0x40, 0x40, 0x40, 0xe0, // 0x231: I
+	0xf0, 0x90, 0xf0, 0x80, 0x80, // 0x236: P
+	0x00, 0x00, 0xf0, 0x00, 0x00, // 0x23b: -
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"chip8-go/chip8"
+)
+
+func TestSplashRom(t *testing.T) {
+	c8 := chip8.New()
+	if err := c8.LoadRomData(splashRom); err != nil {
+		t.Fatal(err)
+	}
+	draws := 0
+	for i := 0; i < 100; i++ {
+		drew, err := c8.RunToDraw(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !drew {
+			break
+		}
+		draws++
+	}
+	if draws != 6 {
+		t.Errorf("%d draws, want 6, one for each of CHIP-8", draws)
+	}
+	ctx := c8.SaveContext()
+	if ctx.PC != 0x22a || ctx.V[0xf] != 0 {
+		t.Errorf("PC = 0x%03x, VF = %d, want the final loop at 0x22a and "+
+			"no collisions", ctx.PC, ctx.V[0xf])
+	}
+	// The pixels of C, H, I, P, - and 8, in a row at (17, 13).
+	on := 0
+	for x := range c8.Gfx {
+		for y, v := range c8.Gfx[x] {
+			if v == 0 {
+				continue
+			}
+			on++
+			if x < 17 || x >= 17+6*5 || y < 13 || y >= 13+5 {
+				t.Errorf("pixel on at (%d, %d), outside the text", x, y)
+			}
+		}
+	}
+	if want := 11 + 12 + 9 + 12 + 4 + 16; on != want {
+		t.Errorf("%d pixels on, want %d", on, want)
+	}
+}
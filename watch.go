@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"chip8-go/chip8"
+)
+
+const watchInterval = 250 * time.Millisecond
+
+// Detects changes of a file by polling its modification time and size. A
+// change is only reported once the file has stayed the same for a poll, so
+// that a file still being written, e.g. by an assembler, isn't read.
+type watcher struct {
+	path    string
+	modTime time.Time
+	size    int64
+	pending bool // Changed since the previous poll
+	polled  time.Time
+}
+
+func newWatcher(path string) *watcher {
+	w := &watcher{path: path}
+	if fi, err := os.Stat(path); err == nil {
+		w.modTime, w.size = fi.ModTime(), fi.Size()
+	}
+	return w
+}
+
+// Polls the file if due at now and reports whether it changed.
+func (w *watcher) changed(now time.Time) bool {
+	if now.Sub(w.polled) < watchInterval {
+		return false
+	}
+	w.polled = now
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		// E.g. removed while being rebuilt, wait for it to come back.
+		return false
+	}
+	if !fi.ModTime().Equal(w.modTime) || fi.Size() != w.size {
+		w.modTime, w.size = fi.ModTime(), fi.Size()
+		w.pending = true
+		return false
+	}
+	changed := w.pending
+	w.pending = false
+	return changed
+}
+
+// Loads the ROM at path again after it changed, and restarts it. On error the
+// previous ROM is still loaded and keeps running.
+func reloadRom(c8 *chip8.Chip8, path string) {
+	if err := c8.LoadRom(path); err != nil {
+		log.Printf("Error reloading ROM: %v", err)
+		return
+	}
+	log.Printf("ROM changed, reloaded")
+	c8.Reset()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"chip8-go/chip8"
+)
+
+func TestWatchReload(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	path := filepath.Join(t.TempDir(), "game.ch8")
+	if err := os.WriteFile(path, []byte{0xa1, 0x00}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c8 := chip8.New()
+	if err := c8.LoadRom(path); err != nil {
+		t.Fatal(err)
+	}
+	w := newWatcher(path)
+	// Each ROM written has a new size, as the modification time may not
+	// change between steps.
+	tests := []struct {
+		name    string
+		rom     []byte // Written before the poll, nil for none
+		changed bool
+		loaded  uint8 // First byte of the ROM loaded after the poll
+	}{
+		{"unchanged", nil, false, 0xa1},
+		{"written", []byte{0xb1, 0, 0, 0}, false, 0xa1},
+		{"stable", nil, true, 0xb1},
+		{"reloaded", nil, false, 0xb1},
+		{"writing", []byte{0xc1, 0, 0, 0, 0, 0}, false, 0xb1},
+		{"still writing", []byte{0xd1, 0, 0, 0, 0, 0, 0, 0}, false, 0xb1},
+		{"written at last", nil, true, 0xd1},
+		{"bad build", bytes.Repeat([]byte{0xe1}, chip8.MaxRomSize+1), false,
+			0xd1},
+		{"bad build stable", nil, true, 0xd1},
+	}
+	now := time.Now()
+	for _, test := range tests {
+		if test.rom != nil {
+			if err := os.WriteFile(path, test.rom, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		now = now.Add(watchInterval)
+		changed := w.changed(now)
+		if changed {
+			reloadRom(c8, path)
+		}
+		if changed != test.changed || c8.Peek(0x200) != test.loaded {
+			t.Errorf("%s: changed %v, ROM starting with %02x, want %v, %02x",
+				test.name, changed, c8.Peek(0x200), test.changed, test.loaded)
+		}
+	}
+	if c8.RomSize() != 8 {
+		t.Errorf("ROM size %d after the bad build, want 8", c8.RomSize())
+	}
+}
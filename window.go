@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/go-gl/glfw/v3.2/glfw"
+)
+
+// A rectangle of the desktop, in screen coordinates.
+type bounds struct {
+	X, Y, Width, Height int
+}
+
+// The window's size and position, saved on exit and restored on the next
+// launch.
+type windowState struct {
+	bounds
+	path string
+}
+
+// Returns the path of the file the window state is saved to.
+func windowStatePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "chip8-go", "window.json"), nil
+}
+
+// Reads the saved window state. A missing file is not an error, the size is
+// then zero.
+func readWindowState(path string) (*windowState, error) {
+	state := windowState{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state.bounds); err != nil {
+		return nil, fmt.Errorf("Error reading %s: %v", path, err)
+	}
+	return &state, nil
+}
+
+func (s *windowState) save() error {
+	data, err := json.MarshalIndent(s.bounds, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, append(data, '\n'), 0644)
+}
+
+// Keeps the state up to date as the window is moved and resized. The window
+// can't be queried on exit, it's gone by then.
+func (s *windowState) track(window *glfw.Window) {
+	s.X, s.Y = window.GetPos()
+	s.Width, s.Height = window.GetSize()
+	window.SetPosCallback(func(w *glfw.Window, x, y int) {
+		s.X, s.Y = x, y
+	})
+	window.SetSizeCallback(func(w *glfw.Window, width, height int) {
+		s.Width, s.Height = width, height
+	})
+}
+
+// Returns the areas of the connected monitors, the primary one first.
+func monitorBounds() []bounds {
+	var areas []bounds
+	primary := glfw.GetPrimaryMonitor()
+	for _, m := range glfw.GetMonitors() {
+		mode := m.GetVideoMode()
+		if mode == nil {
+			continue
+		}
+		x, y := m.GetPos()
+		area := bounds{x, y, mode.Width, mode.Height}
+		if m == primary {
+			areas = append([]bounds{area}, areas...)
+		} else {
+			areas = append(areas, area)
+		}
+	}
+	return areas
+}
+
+// Returns the area b and o have in common, 0 if they don't overlap.
+func (b bounds) overlap(o bounds) int {
+	w := min(b.X+b.Width, o.X+o.Width) - max(b.X, o.X)
+	h := min(b.Y+b.Height, o.Y+o.Height) - max(b.Y, o.Y)
+	if w <= 0 || h <= 0 {
+		return 0
+	}
+	return w * h
+}
+
+// Returns the bounds moved and shrunk to fit on the monitor they overlap
+// most, or on the first monitor if they're off screen, e.g. because the
+// monitor they were on was disconnected. Without monitors, b is returned as
+// is.
+func (b bounds) clamp(monitors []bounds) bounds {
+	if len(monitors) == 0 {
+		return b
+	}
+	area, best := monitors[0], 0
+	for _, m := range monitors {
+		if o := b.overlap(m); o > best {
+			area, best = m, o
+		}
+	}
+	b.Width = min(b.Width, area.Width)
+	b.Height = min(b.Height, area.Height)
+	b.X = max(area.X, min(b.X, area.X+area.Width-b.Width))
+	b.Y = max(area.Y, min(b.Y, area.Y+area.Height-b.Height))
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWindowStateSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chip8-go", "window.json")
+	state, err := readWindowState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.bounds != (bounds{}) {
+		t.Errorf("bounds = %+v without a saved state, want zero", state.bounds)
+	}
+	state.bounds = bounds{100, 50, 960, 480}
+	if err := state.save(); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := readWindowState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.bounds != state.bounds {
+		t.Errorf("restored %+v, want %+v", restored.bounds, state.bounds)
+	}
+}
+
+func TestClampBounds(t *testing.T) {
+	monitors := []bounds{
+		{0, 0, 1920, 1080},    // Primary
+		{1920, 0, 1280, 1024}, // To the right
+	}
+	tests := []struct {
+		name     string
+		in       bounds
+		want     bounds
+		monitors []bounds
+	}{
+		{"visible", bounds{100, 100, 960, 480}, bounds{100, 100, 960, 480},
+			monitors},
+		{"second monitor", bounds{2000, 100, 960, 480},
+			bounds{2000, 100, 960, 480}, monitors},
+		{"straddling", bounds{1800, 100, 960, 480},
+			bounds{1920, 100, 960, 480}, monitors},
+		{"off the bottom", bounds{100, 1000, 960, 480},
+			bounds{100, 600, 960, 480}, monitors},
+		{"disconnected monitor", bounds{3500, 200, 960, 480},
+			bounds{960, 200, 960, 480}, monitors},
+		{"negative position", bounds{-2000, -500, 960, 480},
+			bounds{0, 0, 960, 480}, monitors},
+		{"larger than monitor", bounds{2000, 0, 1600, 1200},
+			bounds{1920, 0, 1280, 1024}, monitors},
+		{"no monitors", bounds{-2000, -500, 960, 480},
+			bounds{-2000, -500, 960, 480}, nil},
+	}
+	for _, test := range tests {
+		if got := test.in.clamp(test.monitors); got != test.want {
+			t.Errorf("%s: clamp(%+v) = %+v, want %+v",
+				test.name, test.in, got, test.want)
+		}
+	}
+}